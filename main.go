@@ -15,9 +15,10 @@ type testObj struct {
 // Data is stored in data objects which are the source of truth for a given piece of data.
 // These DataObjects can be looked up in a set of hash maps which allow for quick access of the data
 // An arbitrary number of these hash maps can be created
-// Does not handle compound indexes - this is key value only - can potentially implement this using a struct as a key though
+// Compound indexes spanning several fields are keyed by a comparable tuple of the field values, see sc.Key
 // You can mix and match structs in a given table so long as each struct has all the minimum index fields
-// All struct fields must be exported, ie. uppercased otherwise they can't be used => panic in the reflection code
+// Indexed struct fields must be exported, ie. uppercased, since they are read by reflection. Rows can implement
+// sc.Keyer to hand over their own keys instead, and map[string]interface{} rows are indexed by their keys
 
 // FAQ
 // Doesn't library XYZ already do this?
@@ -0,0 +1,120 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// Struct type with the given number of int fields F0, F1 ...
+func benchType(fields int) reflect.Type {
+	structFields := make([]reflect.StructField, fields)
+	for i := range structFields {
+		structFields[i] = reflect.StructField{Name: fmt.Sprintf("F%d", i), Type: reflect.TypeOf(0)}
+	}
+	return reflect.StructOf(structFields)
+}
+
+// Row of typ with every field set to n
+func benchRow(typ reflect.Type, n int) interface{} {
+	row := reflect.New(typ).Elem()
+	for i := 0; i < typ.NumField(); i++ {
+		row.Field(i).SetInt(int64(n))
+	}
+	return row.Interface()
+}
+
+// Insert and delete a row each iteration so the table stays the same size and only the cost of working out a row's
+// keys and updating the indexes is measured
+func BenchmarkInsert(b *testing.B) {
+	for _, fields := range []int{10, 100} {
+		for _, indexes := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("fields=%d/indexes=%d", fields, indexes), func(b *testing.B) {
+				if indexes > fields {
+					b.Skip("more indexes than fields")
+				}
+				typ := benchType(fields)
+				names := make([]string, indexes)
+				for i := range names {
+					names[i] = fmt.Sprintf("F%d", i)
+				}
+				table, _ := sc.InitDb("benchdb").AddTable("bench", names...)
+				rows := make([]interface{}, 1000)
+				for i := range rows {
+					rows[i] = benchRow(typ, i)
+				}
+
+				b.ResetTimer()
+				start := time.Now()
+				for i := 0; i < b.N; i++ {
+					if err := table.InsertData(rows[i % len(rows)]); err != nil {
+						b.Fatal(err)
+					}
+					table.Delete("F0", i % len(rows))
+				}
+				b.ReportMetric(float64(b.N) / time.Since(start).Seconds(), "inserts/s")
+			})
+		}
+	}
+}
+
+// Live heap per row once a table is full, with the same rows indexed more and more times. Each extra index should
+// only cost a key and a map entry, not another copy of the row
+func BenchmarkTableMemory(b *testing.B) {
+	const rowCount = 10000
+	for _, indexes := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("fields=100/indexes=%d", indexes), func(b *testing.B) {
+			typ := benchType(100)
+			names := make([]string, indexes)
+			for i := range names {
+				names[i] = fmt.Sprintf("F%d", i)
+			}
+			b.ReportAllocs()
+			var perRow float64
+			for i := 0; i < b.N; i++ {
+				before := heapInUse()
+				table, _ := sc.InitDb("benchdb").AddTable("bench", names...)
+				for n := 0; n < rowCount; n++ {
+					table.SetData(benchRow(typ, n))
+				}
+				perRow = float64(heapInUse() - before) / rowCount
+				runtime.KeepAlive(table)
+			}
+			b.ReportMetric(perRow, "heapB/row")
+		})
+	}
+}
+
+// Bytes of live heap after a full collection
+func heapInUse() int64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
+
+// Take a snapshot, write a row while it's live and release it. Should cost the same however big the table is, since
+// nothing is copied and only the row written keeps an old version
+func BenchmarkSnapshot(b *testing.B) {
+	for _, rowCount := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("rows=%d", rowCount), func(b *testing.B) {
+			typ := benchType(10)
+			table, _ := sc.InitDb("benchdb").AddTable("bench", "F0", "F1", "F2", "F3")
+			for n := 0; n < rowCount; n++ {
+				table.SetData(benchRow(typ, n))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				snap := table.Snapshot()
+				if err := table.SetData(benchRow(typ, i % rowCount)); err != nil {
+					b.Fatal(err)
+				}
+				snap.Release()
+			}
+		})
+	}
+}
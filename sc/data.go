@@ -1,51 +1,350 @@
 package sc
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 	"github.com/pkg/errors"
 )
 
 // Overall db container. There can be multiple of these
+// All methods are safe for concurrent use. mu only guards the Tables map, each table has its own lock
 type Database struct {
 	Name string
-	Tables map[string]Table
-
+	Tables map[string]*Table
+	mu sync.RWMutex
+	// background goroutine removing expired rows, started the first time a row gets a TTL and stopped by Close
+	janitorOnce sync.Once
+	closeOnce sync.Once
+	stopJanitor chan struct{}
+	janitorDone chan struct{}
+	// where debug events about writes go, see WithLogger. Discards everything by default
+	logger *slog.Logger
+	// logger takes debug events, so rows remember their keys for checkDrift
+	debug bool
+	// write-ahead log every change goes to, nil unless the db came from Open
+	wal *wal
+	syncPolicy SyncPolicy
 }
 
-// Defines what a table is. Basically just maps which serve as indexes to underlying data
+// Defines what a table is. Basically a store of rows plus maps which serve as indexes to them
+// All methods are safe for concurrent use. A single RWMutex guards every index in the table so a write to all the
+// indexes appears atomically to readers, ie. nobody can see a row under Id but not yet under Username.
+// Reading Indexes directly bypasses the lock, so only do that when no other goroutine is writing.
 type Table struct {
 	Name string
 	Indexes map[string]Index
-
+	mu sync.RWMutex
+	// every row in the table by row ID, each in its own record which the indexes point to
+	rows map[rowID]*record
+	// last row ID handed out
+	nextID rowID
+	// field name of the primary key index, used to tell rows apart when secondary keys collide
+	pk string
+	// indexes still being backfilled by AddIndexInBackground. Writers keep them up to date but they aren't in
+	// Indexes, and so not visible to lookups, until the build is finished
+	building map[string]*indexBuild
+	db *Database
+	// TTL given to rows which aren't set with an explicit one, 0 means they never expire
+	defaultTTL time.Duration
+	// rows which have an expiry time, and the same in expiry order for the janitor
+	expiring map[rowID]*record
+	expiryQueue expiryHeap
+	// limits on the table size and what gets evicted to stay under them, see TableOptions
+	maxRows int
+	maxBytes int64
+	policy EvictionPolicy
+	evictor Evictor
+	// guards evictor.Accessed which readers call while only holding the read lock
+	evictMu sync.Mutex
+	onEvict func(row interface{}, reason EvictReason)
+	// approximate size of all the rows, only tracked when maxBytes is set
+	bytes int64
+	// rows evicted or expired while the write lock was held, handed to onEvict once it is released
+	evicted []evictedRow
+	// rows are deep copied going in and coming out, see TableOptions.CopyRows
+	copyRows bool
+	// last version given to a row, see bump
+	version uint64
+	// set while a transaction holds the table, which has every change logged in undo so it can be rolled back
+	inTx bool
+	undo []undoEntry
+	// the db's write-ahead log, nil if it has none or the table was dropped. Every write appends its undo log to it
+	wal *wal
+	// where each live snapshot starts in history, oldest first. Snapshot adds to it under the read lock, guarded
+	// by snapMu, so writers see it as it was when they got the write lock
+	snapshots []uint64
+	snapMu sync.Mutex
+	// old versions of rows written since the oldest live snapshot was taken, histBase being the position of the
+	// first of them counting from when the table was made, see TableSnapshot
+	history []rowVersion
+	histBase uint64
 }
 
+// Unique indexes map each key straight to the record holding its row.
+// Non unique indexes map each key to a bucket of records by row ID. Records are internal so use LookupKey and
+// LookupAll to get rows back, Idx is only useful from outside for counting keys
+// Fields are the struct fields making up the key. A single field index is keyed by the field value itself, a compound
+// index spanning several fields is keyed by a CompoundKey of the values in the same order
+// Sorted and ordered indexes keep the same map so LookupKey works on them, plus a skip list of the records in key order
 type Index struct {
 	Idx map[interface{}]interface{}
+	Unique bool
+	Fields []string
+	Kind IndexKind
+	// string keys are lowercased going in and when looking them up
+	Lower bool
+	// the field is a slice and the row is stored under each element
+	Multi bool
+	sorted *skipList
+}
+
+// What an index can do on top of looking rows up by key
+type IndexKind int
+
+const (
+	// Plain hash index, the default
+	HashIndex IndexKind = iota
+	// Keeps rows ordered by a single numeric field, eg. a leaderboard on Score. Supports Rank, RangeByRank,
+	// RangeByScore and Score
+	SortedIndex
+	// Keeps rows ordered by a single time.Time, integer, float or string field. Supports Range, and Prefix on strings
+	OrderedIndex
+)
+
+// Options for a single index
+type IndexOptions struct {
+	// Unique indexes hold at most one row per key and InsertData rejects duplicates. Non unique indexes hold any
+	// number of rows per key, use LookupAll to get all of them back
+	Unique bool
+	// Struct fields the index is keyed on. Leave empty to use the field with the same name as the index.
+	// List several for a compound index eg. "Country_City": {Fields: []string{"Country", "City"}}
+	// A field can be promoted from an embedded struct or be a dotted path to a nested one eg. "Address.PostCode".
+	// A row with a nil pointer on the way to a field has no key and is left out of the index, see Index.keyOf
+	Fields []string
+	// HashIndex unless set. Sorted and ordered indexes must be on a single field and can't be the primary key
+	Kind IndexKind
+	// Case insensitive index, string keys are lowercased when rows are stored and when they are looked up
+	Lower bool
+	// Index a slice field by each of its elements, eg. Tags []string so LookupAll("Tags", "go") finds every row
+	// tagged go. Must be a non unique hash index on a single field
+	Multi bool
+}
+
+// Options for creating a table with AddTableWithOptions
+type TableOptions struct {
+	// Name of the index which identifies a row. This is always unique and is required. It is a single field of the
+	// same name unless Indexes has an entry for it with Fields set, which allows for a compound primary key
+	PrimaryKey string
+	// Secondary indexes keyed by index name
+	Indexes map[string]IndexOptions
+	// Rows expire this long after they are set unless set with their own TTL. 0 means rows don't expire
+	DefaultTTL time.Duration
+	// Most rows the table can hold. Once full, writes evict rows chosen by Eviction. 0 means no limit
+	MaxRows int
+	// Rough memory budget for the table in bytes, see approxSize for how rows are measured. 0 means no limit
+	MaxBytes int64
+	// Which rows get evicted when the table is over MaxRows or MaxBytes, eg. sc.LRU. Defaults to LRU
+	Eviction EvictionPolicy
+	// Called with each row which is evicted or expires, and the reason why. Runs after the table lock is released
+	OnEvict func(row interface{}, reason EvictReason)
+	// Deep copy rows when they are written and again whenever one is handed back, so changing a row after setting
+	// it, or changing one returned by a lookup, can't leave it under stale keys. Costs a copy per row read or written
+	// for rows with pointers, slices or maps in them. Without this rows are shared with callers, who must set a row
+	// again after changing it, see checkDrift
+	CopyRows bool
+}
+
+// Most fields a compound index can span
+const maxKeyFields = 8
+
+// Comparable tuple of field values used as the key in compound indexes. Build one with Key
+type CompoundKey struct {
+	fields [maxKeyFields]interface{}
+	n int
+}
+
+// Make the key to look up a compound index with, passing the values in the same order as the index fields
+// eg. table.LookupKey(sc.Key("NZ", "Auckland"), "Country_City")
+// Panics if given more than 8 values since no index can span that many fields
+func Key(values... interface{}) CompoundKey {
+	if len(values) > maxKeyFields {
+		panic(fmt.Sprintf("sc.Key given %d values, compound keys hold at most %d", len(values), maxKeyFields))
+	}
+	key := CompoundKey{n: len(values)}
+	copy(key.fields[:], values)
+	return key
+}
+
+func (k CompoundKey) String() string {
+	return fmt.Sprint(k.fields[:k.n])
 }
 
 //TODO do we even want the concept of Db or table
 // TODO ensure name is unique
-func InitDb(name string) Database {
-	db := Database{Name: name, Tables: make(map[string]Table)}
+// Options change the defaults eg. sc.InitDb("main", sc.WithLogger(logger))
+func InitDb(name string, opts... Option) *Database {
+	db := &Database{
+		Name: name,
+		Tables: make(map[string]*Table),
+		stopJanitor: make(chan struct{}),
+		janitorDone: make(chan struct{}),
+		logger: silentLogger,
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	db.debug = db.logger.Enabled(context.Background(), slog.LevelDebug)
 	return db
 }
 
 // Add a table to the db if it hasn't already been added
 // Set an empty table index map too which will be filled with data
 // during the Table.AddData process
-func (db Database) AddTable(tableName string, indexes... string) (Table, error) {
+// Every index is unique and the first one is used as the primary key. Use AddTableWithOptions for non unique indexes
+func (db *Database) AddTable(tableName string, indexes... string) (*Table, error) {
+	opts := TableOptions{Indexes: make(map[string]IndexOptions)}
+	for i, idx := range indexes {
+		if i == 0 {
+			opts.PrimaryKey = idx
+			continue
+		}
+		opts.Indexes[idx] = IndexOptions{Unique: true}
+	}
+	return db.addTable(tableName, opts)
+}
+
+// Add a table to the db with a primary key and a set of secondary indexes which can each be unique or non unique
+func (db *Database) AddTableWithOptions(tableName string, opts TableOptions) (*Table, error) {
+	if opts.PrimaryKey == "" {
+		return nil, fmt.Errorf("Table %s needs a primary key", tableName)
+	}
+	if idxOpts, ok := opts.Indexes[opts.PrimaryKey]; ok && !idxOpts.Unique {
+		return nil, fmt.Errorf("Primary key %s can't be a non unique index", opts.PrimaryKey)
+	}
+	for idx, idxOpts := range opts.Indexes {
+		if err := checkIndexOptions(idx, idxOpts, idx == opts.PrimaryKey); err != nil {
+			return nil, err
+		}
+	}
+	return db.addTable(tableName, opts)
+}
+
+// Make sure an index definition makes sense before creating it
+func checkIndexOptions(name string, opts IndexOptions, primaryKey bool) error {
+	if len(opts.Fields) > maxKeyFields {
+		return fmt.Errorf("Index %s spans %d fields, at most %d are allowed", name, len(opts.Fields), maxKeyFields)
+	}
+	if opts.Kind != HashIndex {
+		if primaryKey {
+			return fmt.Errorf("Primary key %s can't be a sorted or ordered index", name)
+		}
+		if len(opts.Fields) > 1 {
+			return fmt.Errorf("Sorted or ordered index %s must be on a single field", name)
+		}
+	}
+	if opts.Multi {
+		if primaryKey || opts.Unique {
+			return fmt.Errorf("Multi value index %s can't be unique", name)
+		}
+		if opts.Kind != HashIndex || len(opts.Fields) > 1 {
+			return fmt.Errorf("Multi value index %s must be a hash index on a single field", name)
+		}
+	}
+	return nil
+}
+
+// A new empty index from its options
+func newIndex(name string, opts IndexOptions) Index {
+	idx := Index{
+		Idx: make(map[interface{}]interface{}),
+		Unique: opts.Unique,
+		Fields: indexFields(name, opts),
+		Kind: opts.Kind,
+		Lower: opts.Lower,
+		Multi: opts.Multi,
+	}
+	switch opts.Kind {
+	case SortedIndex:
+		idx.sorted = newSkipList(compareScores)
+	case OrderedIndex:
+		idx.sorted = newSkipList(compareOrdered)
+	}
+	return idx
+}
+
+// The struct fields making up an index, which default to the field with the same name as the index
+func indexFields(name string, opts IndexOptions) []string {
+	if len(opts.Fields) == 0 {
+		return []string{name}
+	}
+	return opts.Fields
+}
+
+// Every struct field used by any index in the table options
+func (opts TableOptions) fields() []string {
+	fields := indexFields(opts.PrimaryKey, opts.Indexes[opts.PrimaryKey])
+	for idx, idxOpts := range opts.Indexes {
+		if idx != opts.PrimaryKey {
+			fields = append(fields, indexFields(idx, idxOpts)...)
+		}
+	}
+	return fields
+}
+
+func (db *Database) addTable(tableName string, opts TableOptions) (*Table, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	if _, ok := db.Tables[tableName]; ok {
 		return db.Tables[tableName], fmt.Errorf("Table %s already exists in db %s", tableName, db.Name)
 	}
-	// TODO need to set the indexes for this table too
+	create := walTableOptions{
+		PrimaryKey: opts.PrimaryKey,
+		Indexes: opts.Indexes,
+		DefaultTTL: opts.DefaultTTL,
+		MaxRows: opts.MaxRows,
+		MaxBytes: opts.MaxBytes,
+		CopyRows: opts.CopyRows,
+	}
+	if err := db.logSchema(walOp{Kind: walCreateTable, Table: tableName, TableOptions: create}); err != nil {
+		return nil, err
+	}
 	idxMap := make(map[string]Index)
-	for _, idx := range indexes {
-		idxMap[idx] = Index{Idx: make(map[interface{}]interface{})}
-		//idxMap[idx] = Index{Idx: make(map[interface{}]*interface{})}
+	for idx, idxOpts := range opts.Indexes {
+		idxMap[idx] = newIndex(idx, idxOpts)
+	}
+	if opts.PrimaryKey != "" {
+		pkOpts := opts.Indexes[opts.PrimaryKey]
+		pkOpts.Unique = true
+		idxMap[opts.PrimaryKey] = newIndex(opts.PrimaryKey, pkOpts)
 	}
 
-	table := Table{Name: tableName, Indexes: idxMap}
+	table := &Table{
+		Name: tableName,
+		Indexes: idxMap,
+		pk: opts.PrimaryKey,
+		rows: make(map[rowID]*record),
+		building: make(map[string]*indexBuild),
+		db: db,
+		defaultTTL: opts.DefaultTTL,
+		expiring: make(map[rowID]*record),
+		maxRows: opts.MaxRows,
+		maxBytes: opts.MaxBytes,
+		onEvict: opts.OnEvict,
+		copyRows: opts.CopyRows,
+		wal: db.wal,
+	}
+	if opts.MaxRows > 0 || opts.MaxBytes > 0 {
+		table.policy = opts.Eviction
+		if table.policy == nil {
+			table.policy = LRU
+		}
+		table.evictor = table.policy()
+	}
 	db.Tables[tableName] = table
 
 	return table, nil
@@ -53,9 +352,6 @@ func (db Database) AddTable(tableName string, indexes... string) (Table, error)
 
 // For each type of index we've set on this table (during table creation) link to the data
 // Can do a bulk insert by passing in the data as a slice of interfaces{}
-// TODO can we handle non-unique indexes? or how do we want to do that
-//	current implementation will overwrite, but should we at least warn user about that
-//    can have future feature where you can turn uniqueness on at table or even table:index level
 //func (tbl Table) AddData(data... interface{}) error {
 //
 //	for _, d := range data {
@@ -88,179 +384,496 @@ func (db Database) AddTable(tableName string, indexes... string) (Table, error)
 // Does the actual work of adding data objects to a table. Does not check before overwriting existing data since
 // that is the job of any methods calling this one.
 // This automatically figures out what the indexes are based on the table definition
-func (tbl Table) addData(data... interface{}) error {
+// Rows expire after ttl, or never if ttl is 0
+// Caller must hold the table write lock
+func (tbl *Table) addData(ttl time.Duration, data... interface{}) error {
 	// TODO would this be any faster if I made a separate go routine for each data object in the slice??
 	// Potentially see https://hackernoon.com/dancing-with-go-s-mutexes-92407ae927bf for tips on syncing
 	// or https://blog.golang.org/share-memory-by-communicating for using channels and go routines together
+	// check everything first so a bad row part way through doesn't leave half a batch behind
 	for _, d := range data {
-		if !HasRequiredIndexes(tbl, d) {
-			return fmt.Errorf("Data obj %s doesn't have all necessary indexes in %s", d, tbl.Name)
+		if err := tbl.checkRow(d); err != nil {
+			return err
 		}
-		fmt.Println("data", d, &d, reflect.TypeOf(d))
-		for k := range tbl.Indexes {
-			//fmt.Println(k, v)
-			idxMap := tbl.Indexes[k]
-			// find corresponding type/field in data
-			refValOf := reflect.ValueOf(d)
-			val := reflect.Indirect(refValOf)
-			for i := 0; i < val.NumField(); i++ {
-				fieldName := val.Type().Field(i).Name
-				fieldVal := val.Field(i).Interface()
-				//fmt.Println("This is field Name and Val", fieldName, fieldVal)
-				if k == fieldName {
-					idxMap.Idx[fieldVal] = d
-				}
+	}
+	if err := tbl.checkBatchKeys(data); err != nil {
+		return err
+	}
+	for _, d := range data {
+		if tbl.copyRows {
+			d = copyRow(d)
+		}
+		fields := fieldsOf(d)
+		pk, _ := tbl.Indexes[tbl.pk].keyOf(fields)
+		// an older version of this row is overwritten in its record, and moved off any keys it no longer has so it
+		// doesn't linger under them in non unique indexes
+		rec, replaced := tbl.Indexes[tbl.pk].get(pk)
+		if replaced {
+			tbl.replace(rec, d, fields)
+		} else {
+			rec = tbl.newRecord(pk, d)
+			tbl.link(rec, fields)
+			if tbl.db.debug {
+				rec.keys = tbl.indexKeys(fields)
 			}
 		}
-		//fmt.Println("NEW DATA", d)
-		fmt.Println("NEW TABLE", tbl)
-		tbl.PrettyPrint()
+		if replaced {
+			tbl.db.logger.Debug("update", "table", tbl.Name, "pk", pk)
+		} else {
+			tbl.db.logger.Debug("insert", "table", tbl.Name, "pk", pk)
+		}
+		tbl.setExpiry(rec, ttl)
+		tbl.track(rec, replaced)
+		tbl.enforceLimits()
 	}
 	return nil
 }
 
 // Inserts new Data objects if they don't already exist. Will fail if data already exists for a given key.
-// If slice contains data with overlapping keys then only one will win out in a non-deterministic fashion
-func (tbl Table) InsertData(data... interface{}) error {
+// Only the indexed field of each index is checked. The check and the write happen under one table lock so two
+// goroutines inserting conflicting rows can't both succeed.
+// A batch is all or nothing: if any row collides with existing data, or with another row earlier in the same slice,
+// nothing is inserted and an ErrDuplicateKey naming the index and key is returned
+func (tbl *Table) InsertData(data... interface{}) error {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commit(tbl.insertData(tbl.defaultTTL, data...))
+}
 
+// Does the work of InsertData. Caller must hold the table write lock
+func (tbl *Table) insertData(ttl time.Duration, data... interface{}) error {
+	// keys claimed by earlier rows in this batch, per index
+	batchKeys := make(map[string]map[interface{}]bool)
+	for idx := range tbl.Indexes {
+		batchKeys[idx] = make(map[interface{}]bool)
+	}
 	for _, d := range data {
-		// find
-		fmt.Println("data", d)
-		refValOf := reflect.ValueOf(d)
-		val := reflect.Indirect(refValOf)
+		if err := tbl.checkRow(d); err != nil {
+			return err
+		}
+		fields := fieldsOf(d)
 		for idx := range tbl.Indexes {
-
-			for i := 0; i < val.NumField(); i++ {
-				//fieldName := val.Type().Field(i).Name
-				fieldVal := val.Field(i).Interface()
-				fmt.Println("idx, value", idx, reflect.TypeOf(idx), fieldVal)
-				lookupVal := tbl.LookupKey(fieldVal, idx)
-				fmt.Println("Insert data", len(data), idx, lookupVal)
-
-				if lookupVal != nil {
-					fmt.Println("Data already exists")
-					tbl.PrettyPrint()
-					return errors.New("Data already exists")
-				}
+			if !tbl.Indexes[idx].Unique {
+				continue
+			}
+			// rows without a key aren't in the index so can't collide there
+			key, ok := tbl.Indexes[idx].keyOf(fields)
+			if !ok {
+				continue
 			}
+			if _, exists := tbl.liveRecord(idx, key); exists || batchKeys[idx][key] {
+				tbl.db.logger.Debug("conflict", "table", tbl.Name, "index", idx, "key", key)
+				return ErrDuplicateKey{Index: idx, Key: key}
+			}
+			batchKeys[idx][key] = true
 		}
-		// Only add if keys are unique on ALL indexes in the map
-		// Can only do this serially currently otherwise if you pass in the same keys in the same slice
-		// there will be trouble. Can maybe synchronize with channels/mutex??
-		tbl.addData(d)
 	}
-	tbl.PrettyPrint()
-	return nil
+	// Only add once keys are known to be unique on ALL indexes for the whole batch
+	return tbl.addData(ttl, data...)
 }
 
 // Convenience function which is a thin wrapper around AddData()
 // Overwrites existing data if it's there and inserts data if it didn't previously exist
-func (tbl Table) SetData(data... interface{}) error {
-	return tbl.addData(data...)
+// Rows get the table's default TTL if it has one
+func (tbl *Table) SetData(data... interface{}) error {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commit(tbl.addData(tbl.defaultTTL, data...))
 }
 
-// For a given struct creates a map of struct field names to values
-// TODO hook this up to other loops in the code
-func getStructFieldAndVal(data interface{}) map[string]interface{} {
-	refVal := reflect.ValueOf(data)
-	val := reflect.Indirect(refVal)
-	resultMap := make(map[string]interface{})
-	for i := 0; i < val.NumField(); i++ {
-		fieldName := val.Type().Field(i).Name
-		fieldVal := val.Field(i).Interface()
-		resultMap[fieldName] = fieldVal
+// Only update data which already exists, finding each row by its primary key. Any other field can change: the row
+// is taken out from under its old keys in every index and stored under its new ones.
+// Fails with ErrNotFound if a row isn't there, or ErrDuplicateKey if a new key belongs to some other row in a unique
+// index. A batch is all or nothing, nothing is updated unless every row can be.
+// Updated rows keep whatever expiry time they already had
+func (tbl *Table) UpdateData(data... interface{}) error {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commit(tbl.updateBy(tbl.pk, data...))
+}
+
+// Return the row stored under key in the given index or nil if there isn't one. Expired rows are never returned.
+// For a non unique index this is any one of the matching rows, use LookupAll to get all of them
+func (tbl *Table) LookupKey(key interface{}, idx string) interface{} {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.lookupKey(key, idx)
+}
+
+// Lock free version of LookupKey for use by methods already holding the table lock
+func (tbl *Table) lookupKey(key interface{}, idx string) interface{} {
+	rec := tbl.lookupRecord(key, idx)
+	if rec == nil {
+		return nil
 	}
-	return resultMap
+	return tbl.rowOut(rec)
 }
 
-// For a given data object see if it already exists in the table by checking all the table indexes
-func (tbl Table) doAllKeysExist(data interface{}) bool {
-	structMap := getStructFieldAndVal(data)
-	for idx := range tbl.Indexes {
-		if tbl.LookupKey(structMap[idx], idx) == nil {
-			return false
+// The record LookupKey returns the row of, or nil. Counts as an access for eviction. Caller must hold the table lock
+func (tbl *Table) lookupRecord(key interface{}, idx string) *record {
+	now := time.Now()
+	if index := tbl.Indexes[idx]; index.Unique {
+		// straight to the one record, without building a list of them
+		key = index.normalize(key)
+		if !hashable(key) {
+			return nil
 		}
+		rec, ok := index.get(key)
+		if !ok || tbl.expired(rec, now) {
+			return nil
+		}
+		tbl.accessed(rec)
+		return rec
 	}
-	return true
+	for _, rec := range tbl.Indexes[idx].lookup(key) {
+		if !tbl.expired(rec, now) {
+			tbl.accessed(rec)
+			return rec
+		}
+	}
+	return nil
+}
 
+// Return every row stored under key in the given index. Works for unique indexes too, where there is at most one
+func (tbl *Table) LookupAll(idx string, key interface{}) []interface{} {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.results(tbl.Indexes[idx].lookup(key))
 }
 
-// Only update data if it already exists as a key
-// If the key doesn't exist it will fail to add that piece of data
-func (tbl Table) UpdateData(data... interface{}) error {
-	for _, d := range data {
-		keysExist := tbl.doAllKeysExist(d) // will prob need to make a function for reflecting field/value
-		if keysExist {
-			tbl.addData(d)
-		} else {
-			return errors.Errorf("UpdateData DNE: %s", d)
+// Remove the row stored under key in the given index. The row is taken out of every other index too, using the row's
+// own field values to find its keys there.
+// Returns the removed row, or ErrNotFound if the index doesn't exist or there is nothing stored under key
+func (tbl *Table) Delete(index string, key interface{}) (interface{}, error) {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	removed, err := tbl.delete(index, key)
+	if err = tbl.commit(err); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// Does the work of Delete. Caller must hold the table write lock
+func (tbl *Table) delete(index string, key interface{}) (interface{}, error) {
+	if _, ok := tbl.Indexes[index]; !ok {
+		return nil, errors.Wrapf(ErrNotFound, "Index %s does not exist in table %s", index, tbl.Name)
+	}
+	if !tbl.Indexes[index].Unique {
+		return nil, errors.Errorf("Index %s is not unique, use DeleteWhere to remove rows by it", index)
+	}
+	if !hashable(key) {
+		return nil, errors.Wrapf(ErrUnhashableKey, "Key %v for index %s", key, index)
+	}
+	removed := tbl.deleteKey(index, key)
+	if removed == nil {
+		return nil, errors.Wrapf(ErrNotFound, "Key %v in index %s", key, index)
+	}
+	return removed, nil
+}
+
+// Remove every row for which predicate returns true from all indexes. Returns how many rows were removed.
+// The whole sweep happens under the table lock so predicate must not call back into this table. With CopyRows
+// predicate gets a copy of each row
+func (tbl *Table) DeleteWhere(predicate func(row interface{}) bool) int {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	n := tbl.deleteWhere(predicate)
+	if tbl.commit(nil) != nil {
+		return 0
+	}
+	return n
+}
+
+// Does the work of DeleteWhere. Caller must hold the table write lock
+func (tbl *Table) deleteWhere(predicate func(row interface{}) bool) int {
+	var recs []*record
+	now := time.Now()
+	for _, rec := range tbl.rows {
+		if !tbl.expired(rec, now) && predicate(tbl.rowOut(rec)) {
+			recs = append(recs, rec)
 		}
 	}
-	return nil
+	for _, rec := range recs {
+		tbl.deleteRecord(rec)
+	}
+	return len(recs)
+}
+
+// Find the row stored under key in the given unique index and remove it from every index.
+// Returns the removed row or nil if there was nothing under that key. Caller must hold the table write lock
+func (tbl *Table) deleteKey(index string, key interface{}) interface{} {
+	if !tbl.Indexes[index].Unique {
+		return nil
+	}
+	rec, ok := tbl.liveRecord(index, key)
+	if !ok {
+		return nil
+	}
+	tbl.deleteRecord(rec)
+	return rec.row
+}
+
+// Overwrite the row in a record with d, whose field values are fields, and move it to d's keys in every index.
+// Caller must hold the table write lock
+func (tbl *Table) replace(rec *record, d interface{}, fields fieldValues) {
+	tbl.logUndo(undoWrite, rec)
+	tbl.checkDrift(rec)
+	old := fieldsOf(rec.row)
+	rec.row = d
+	tbl.bump(rec)
+	tbl.relink(rec, old, fields)
+	if tbl.db.debug {
+		rec.keys = tbl.indexKeys(fields)
+	}
 }
 
-func (tbl Table) LookupKey(key interface{}, idx string) interface{} {
-	return tbl.Indexes[idx].Idx[key]
+// Remove a row from every index and the row store along with its expiry time and eviction bookkeeping.
+// Caller must hold the table write lock
+func (tbl *Table) deleteRecord(rec *record) {
+	tbl.logUndo(undoDelete, rec)
+	tbl.checkDrift(rec)
+	tbl.unlinkRecord(rec)
+	delete(tbl.rows, rec.id)
+	delete(tbl.expiring, rec.id)
+	tbl.untrack(rec)
 }
 
 // Totally remove the table from the db ie. remove table key from db map
 // Does not remove the underlying data object since they are just stored pointers
 // TODO figure out if use case would be to delete underlying data too
 // TODO figure out if doing this will lead to memory leaks
-func (db Database) DropTable(tableName string) {
+// With a db from Open, writes through a *Table kept from before the drop aren't logged. The drop is logged first,
+// and if that fails the table is left where it is and the error returned
+func (db *Database) DropTable(tableName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tbl, ok := db.Tables[tableName]
+	if !ok {
+		return nil
+	}
+	if db.wal != nil {
+		// writes already holding the table lock are logged before the drop, later ones not at all
+		tbl.mu.Lock()
+		defer tbl.mu.Unlock()
+		if err := db.logSchema(walOp{Kind: walDropTable, Table: tableName}); err != nil {
+			return err
+		}
+		tbl.wal = nil
+	}
 	delete(db.Tables, tableName)
+	return nil
+}
+
+// Get a table by name. Use this rather than reading db.Tables directly when other goroutines may be adding or
+// dropping tables
+func (db *Database) GetTable(tableName string) (*Table, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	table, ok := db.Tables[tableName]
+	return table, ok
 }
 
 // Keeps the table as a key in the map, but removes all values associated with it
 // Doesn't actually delete the underlying data objects or indexes.
-func (tbl Table) CleanTableData() {
+func (tbl *Table) CleanTableData() {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	if tbl.wal != nil {
+		if err := tbl.wal.append([]walOp{{Kind: walClean, Table: tbl.Name}}); err != nil {
+			tbl.db.logger.Error("wal", "table", tbl.Name, "err", err)
+			return
+		}
+	}
 	for idx := range tbl.Indexes {
-		tbl.Indexes[idx] = Index{Idx: make(map[interface{}]interface{})}
+		tbl.Indexes[idx] = tbl.Indexes[idx].empty()
+	}
+	for _, b := range tbl.building {
+		b.idx = b.idx.empty()
+	}
+	tbl.rows = make(map[rowID]*record)
+	tbl.expiring = make(map[rowID]*record)
+	tbl.expiryQueue = nil
+	tbl.bytes = 0
+	if tbl.evictor != nil {
+		tbl.evictor = tbl.policy()
 	}
 }
 
 
 // TODO do we need this function?
 func (idx Index) findByKey(key interface{}) interface{} {
-	return idx.Idx[key]
+	if rec, ok := idx.get(key); ok {
+		return rec.row
+	}
+	return nil
+}
+
+// A new index with the same definition but no data
+func (idx Index) empty() Index {
+	empty := idx
+	empty.Idx = make(map[interface{}]interface{})
+	if idx.sorted != nil {
+		empty.sorted = newSkipList(idx.sorted.cmp)
+	}
+	return empty
 }
 
-func (tbl Table) PrettyPrint() {
-	fmt.Println("TABLE")
-	for k, v := range tbl.Indexes {
-		fmt.Println("Index:", k)
-		for k1, v1 := range v.Idx {
-			fmt.Printf("\t%s :: %s\n", k1, v1)
+// Work out the key for a row from its struct field values.
+// For a multi value index this is the whole slice, use keysOf to get the keys it is stored under.
+// ok is false if the row has no key for this index because a field is behind a nil pointer. Such rows are left out
+// of the index, except for the primary key where checkKeys rejects them
+func (idx Index) keyOf(fields fieldValues) (key interface{}, ok bool) {
+	if len(idx.Fields) == 1 {
+		value, ok := fields.value(idx.Fields[0])
+		return idx.normalize(value), ok
+	}
+	values := make([]interface{}, len(idx.Fields))
+	for i, f := range idx.Fields {
+		value, ok := fields.value(f)
+		if !ok {
+			return nil, false
 		}
+		values[i] = idx.normalize(value)
 	}
+	return Key(values...), true
 }
 
-// Given a table return how many data objects are stored.
-// This gets the count by checking the length of one of the indexes. Uses the assumption that  each index has the
-// same number of data objects per table since that is how the model works.
-func GetTableSize(table Table) int {
-	for k := range table.Indexes {
-		return len(table.Indexes[k].Idx)
+// Every key a row is stored under, which is just its one key unless this is a multi value index
+func (idx Index) keysOf(fields fieldValues) []interface{} {
+	if !idx.Multi {
+		if key, ok := idx.keyOf(fields); ok {
+			return []interface{}{key}
+		}
+		return nil
+	}
+	value, _ := fields.value(idx.Fields[0])
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return nil
+	}
+	keys := make([]interface{}, val.Len())
+	for i := range keys {
+		keys[i] = idx.normalize(val.Index(i).Interface())
+	}
+	return keys
+}
+
+// Put a key the way the index stores it, ie. lowercased for a case insensitive index. Compound keys have each of
+// their strings lowercased. Anything else is returned as is
+func (idx Index) normalize(key interface{}) interface{} {
+	if !idx.Lower {
+		return key
+	}
+	if k, ok := key.(CompoundKey); ok {
+		for i := 0; i < k.n; i++ {
+			k.fields[i] = lower(k.fields[i])
+		}
+		return k
 	}
-	return 0
+	return lower(key)
+}
+
+// Lowercase a string, or a named string type keeping its type
+func lower(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return strings.ToLower(s)
+	}
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.String {
+		return v
+	}
+	return reflect.ValueOf(strings.ToLower(val.String())).Convert(val.Type()).Interface()
+}
+
+// Store a record under key. The key must already have passed checkKey
+func (idx Index) put(key interface{}, rec *record) {
+	if idx.sorted != nil {
+		sortKey, _ := idx.sortKey(key)
+		idx.sorted.insert(sortKey, rec)
+	}
+	idx.store(key, rec)
+}
+
+// The map half of put, leaving any skip list alone
+func (idx Index) store(key interface{}, rec *record) {
+	if idx.Unique {
+		idx.Idx[key] = rec
+		return
+	}
+	bucket, ok := idx.Idx[key].(map[rowID]*record)
+	if !ok {
+		bucket = make(map[rowID]*record)
+		idx.Idx[key] = bucket
+	}
+	bucket[rec.id] = rec
+}
+
+// Remove a record from under key, dropping the bucket once it is empty. A unique key which now belongs to some
+// other record is left alone. This only touches the map, unlink takes care of the skip list of a sorted index
+func (idx Index) remove(key interface{}, rec *record) {
+	if idx.Unique {
+		if idx.Idx[key] == rec {
+			delete(idx.Idx, key)
+		}
+		return
+	}
+	bucket, ok := idx.Idx[key].(map[rowID]*record)
+	if !ok {
+		return
+	}
+	delete(bucket, rec.id)
+	if len(bucket) == 0 {
+		delete(idx.Idx, key)
+	}
+}
+
+// All records stored under key. Nothing is stored under a key which can't be hashed
+func (idx Index) lookup(key interface{}) []*record {
+	key = idx.normalize(key)
+	if !hashable(key) {
+		return nil
+	}
+	val, ok := idx.Idx[key]
+	if !ok {
+		return nil
+	}
+	if idx.Unique {
+		return []*record{val.(*record)}
+	}
+	bucket := val.(map[rowID]*record)
+	recs := make([]*record, 0, len(bucket))
+	for _, rec := range bucket {
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// Given a table return how many data objects are stored.
+// This gets the count from the row store, which holds each data object exactly once, less any rows which have
+// expired but not been removed yet
+func GetTableSize(table *Table) int {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return len(table.rows) - table.countExpired(time.Now())
 }
 
 // Given a table and a data object, determine if the data object has at a minimum all the indexes for the table
-func HasRequiredIndexes(table Table, data interface{}) bool {
-	for k := range table.Indexes {
-		found := false
-		refValOf := reflect.ValueOf(data)
-		val := reflect.Indirect(refValOf)
-		for i := 0; i < val.NumField(); i++ {
-			fieldName := val.Type().Field(i).Name
-			if k == fieldName {
-				found = true
-				break
+func HasRequiredIndexes(table *Table, data interface{}) bool {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.hasRequiredIndexes(data)
+}
+
+// Lock free version of HasRequiredIndexes. Caller must hold the table lock
+func (tbl *Table) hasRequiredIndexes(data interface{}) bool {
+	fields := fieldsOf(data)
+	for _, idx := range tbl.Indexes {
+		for _, f := range idx.Fields {
+			if !fields.has(f) {
+				return false
 			}
 		}
-		if !found {
-			return false
-		}
 	}
 	return true
 }
@@ -268,7 +881,9 @@ func HasRequiredIndexes(table Table, data interface{}) bool {
 // Return all the table names in a given database as a slice
 // TODO benchmark and see how compares to doing this with i := 0 counter rather than range
 // eg http://stackoverflow.com/questions/21362950/golang-getting-a-slice-of-keys-from-a-map claims that would be faster than a range with append
-func (db Database) ListTableNames() []string {
+func (db *Database) ListTableNames() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	tableList := make([]string, 0, len(db.Tables))
 	for k := range db.Tables {
 		tableList = append(tableList, k)
@@ -279,7 +894,9 @@ func (db Database) ListTableNames() []string {
 // Return all the index names in a given table as a slice
 // TODO benchmark and see how compares to doing this with i := 0 counter rather than range
 // eg http://stackoverflow.com/questions/21362950/golang-getting-a-slice-of-keys-from-a-map claims that would be faster than a range with append
-func (tbl Table) ListIndexNames() []string {
+func (tbl *Table) ListIndexNames() []string {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
 	indexList := make([]string, 0, len(tbl.Indexes))
 	for k := range tbl.Indexes {
 		indexList = append(indexList, k)
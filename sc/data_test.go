@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"time"
 	"sort"
+	"sync"
+	"errors"
 )
 
 // To execute tests run: go test ./... -v
@@ -22,7 +24,7 @@ func TestMain(m *testing.M) {
 
 func TestInitDb(t *testing.T) {
 	dbName := "test1"
-	emptyTable := make(map[string]sc.Table)
+	emptyTable := make(map[string]*sc.Table)
 	db1 := sc.InitDb(dbName)
 	if db1.Name != dbName || len(db1.Tables) != 0 || reflect.TypeOf(db1.Tables) != reflect.TypeOf(emptyTable) {
 		t.Fail()
@@ -67,9 +69,47 @@ func TestAddTable(t *testing.T) {
 
 
 
+func TestAddTableWithOptions(t *testing.T) {
+	dbName := "testdb"
+	db := sc.InitDb(dbName)
+
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Username": {Unique: true}, "Country": {Unique: false}},
+	}
+	table, err := db.AddTableWithOptions("testTable", opts)
+	if err != nil || len(table.Indexes) != 3 || !table.Indexes["Id"].Unique || !table.Indexes["Username"].Unique ||
+		table.Indexes["Country"].Unique {
+		fmt.Println("FAIL: AddTableWithOptions", err)
+		t.Fail()
+	}
+
+	// primary key is required
+	if _, err = db.AddTableWithOptions("noPk", sc.TableOptions{}); err == nil {
+		fmt.Println("FAIL: AddTableWithOptions without primary key")
+		t.Fail()
+	}
+
+	// and it can't be non unique
+	opts.Indexes["Id"] = sc.IndexOptions{Unique: false}
+	if _, err = db.AddTableWithOptions("nonUniquePk", opts); err == nil {
+		fmt.Println("FAIL: AddTableWithOptions non unique primary key")
+		t.Fail()
+	}
+
+	// plain AddTable makes every index unique
+	table, _ = db.AddTable("plainTable", "Id", "Username")
+	for idx := range table.Indexes {
+		if !table.Indexes[idx].Unique {
+			fmt.Println("FAIL: AddTable index not unique", idx)
+			t.Fail()
+		}
+	}
+}
+
 // Given a struct and an already created Table this will call the SetData function and validate the results
 // sc.Table will have the indexes you need to test
-func testSetDataHelper(table sc.Table, testObj... interface{}) bool {
+func testSetDataHelper(table *sc.Table, testObj... interface{}) bool {
 
 	tableLen := sc.GetTableSize(table) // keep count for later
 	err := table.SetData(testObj...)
@@ -86,7 +126,7 @@ func testSetDataHelper(table sc.Table, testObj... interface{}) bool {
 				fieldName := val.Type().Field(i).Name
 				fieldVal := val.Field(i).Interface()
 				if k == fieldName {
-					if !reflect.DeepEqual(table.Indexes[k].Idx[fieldVal], tObj) {
+					if !reflect.DeepEqual(table.LookupKey(fieldVal, k), tObj) {
 						fmt.Printf("FAIL: TestSetData retrieved by %s failed\n ", k)
 						return false
 					}
@@ -183,8 +223,9 @@ func TestSetData(t *testing.T) {
 	}
 }
 
-// TODO would also need to test that adding tables are synced to avoid race conditions
-func testAddDataSynchronization(t *testing.T) {
+// Run with -race. Many goroutines write distinct rows while others read, every row must end up in every index and
+// a reader must never see a row under one index but not the other
+func TestAddDataSynchronization(t *testing.T) {
 	dbName := "testdb"
 	db := sc.InitDb(dbName)
 
@@ -200,12 +241,67 @@ func testAddDataSynchronization(t *testing.T) {
 		Username string
 	}
 
-	objId := "tobj1"
-	objUsername := "test_user1"
-	tObj := testObj{Id: objId, Username: objUsername}
-	fmt.Println("SYNC TEST!!!!!!!!!!!!!!")
-	result := testSetDataHelper(table, tObj)
-	if !result {
+	writers := 8
+	rowsPerWriter := 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rowsPerWriter; i++ {
+				tObj := testObj{Id: fmt.Sprintf("id_%d_%d", w, i), Username: fmt.Sprintf("user_%d_%d", w, i)}
+				if err := table.SetData(tObj); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	// readers racing the writers
+	for r := 0; r < writers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < rowsPerWriter; i++ {
+				// a table level lock means if we see the row by Id it must be there by Username as well
+				if byId := table.LookupKey(fmt.Sprintf("id_%d_%d", r, i), "Id"); byId != nil {
+					if table.LookupKey(byId.(testObj).Username, "Username") == nil {
+						t.Errorf("FAIL: row %v visible under Id but not Username", byId)
+					}
+				}
+				sc.GetTableSize(table)
+				table.ListIndexNames()
+			}
+		}(r)
+	}
+	// tables being added and listed at the same time
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := db.AddTable(fmt.Sprintf("syncTable%d", i), "Id"); err != nil {
+				t.Error(err)
+			}
+			db.ListTableNames()
+			db.GetTable(tableName)
+		}(i)
+	}
+	wg.Wait()
+
+	if sc.GetTableSize(table) != writers * rowsPerWriter {
+		fmt.Println("FAIL: TestAddDataSynchronization table size", sc.GetTableSize(table))
+		t.Fail()
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < rowsPerWriter; i++ {
+			tObj := testObj{Id: fmt.Sprintf("id_%d_%d", w, i), Username: fmt.Sprintf("user_%d_%d", w, i)}
+			if table.LookupKey(tObj.Id, "Id") != tObj || table.LookupKey(tObj.Username, "Username") != tObj {
+				fmt.Println("FAIL: TestAddDataSynchronization missing row", tObj)
+				t.Fail()
+			}
+		}
+	}
+	if len(db.ListTableNames()) != writers + 1 {
+		fmt.Println("FAIL: TestAddDataSynchronization table count", db.ListTableNames())
 		t.Fail()
 	}
 }
@@ -343,17 +439,109 @@ func TestInsertDataAtomically(t *testing.T) {
 
 
 	// Test where obj1 and obj2 are inserting as the same slice eg InsertData(obj1, obj2)
-	// TODO can we make this logic simpler?
+	// They collide with each other so the whole batch should be rejected and nothing inserted
 	table.CleanTableData()
-	table.InsertData(obj1, obj2)
-	if !(table.LookupKey(objId1, "Id").(testObj).Misc == 1 && table.LookupKey(objUser1, "Username").(testObj).Misc == 1 ||
-		table.LookupKey(objId1, "Id").(testObj).Misc == 2 && table.LookupKey(objUser1, "Username").(testObj).Misc == 2) {
-
+	err = table.InsertData(obj1, obj2)
+	if err == nil || table.LookupKey(objId1, "Id") != nil || table.LookupKey(objUser1, "Username") != nil {
 		fmt.Println("FAIL: Batch Insert atomic data Id")
 		t.Fail()
 	}
 
+	// Test where obj1 and obj2 are racing in different goroutines. Exactly one should win and both indexes must agree
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+	for _, obj := range []testObj{obj1, obj2} {
+		wg.Add(1)
+		go func(obj testObj) {
+			defer wg.Done()
+			if table.InsertData(obj) == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}(obj)
+	}
+	wg.Wait()
+	byId := table.LookupKey(objId1, "Id")
+	byUser := table.LookupKey(objUser1, "Username")
+	if wins != 1 || byId == nil || byUser == nil || byId.(testObj).Misc != byUser.(testObj).Misc {
+		fmt.Println("FAIL: Concurrent Insert atomic data", wins, byId, byUser)
+		t.Fail()
+	}
+}
+
+func TestInsertDataUniqueness(t *testing.T) {
+	dbName := "testdb"
+	db := sc.InitDb(dbName)
+
+	tableName := "testTable"
+	table, err := db.AddTable(tableName, "Id", "Username")
+	if err != nil {
+		t.Fail()
+	}
+	type testObj struct {
+		Id string
+		Username string
+		Misc string
+	}
+
+	obj1 := testObj{Id: "Id1", Username: "User1", Misc: "x"}
+	if err := table.InsertData(obj1); err != nil {
+		fmt.Println("FAIL: Insert initial data", err)
+		t.Fail()
+	}
+
+	// a non indexed field holding an existing key shouldn't matter, and neither should a key from another index
+	obj2 := testObj{Id: "User1", Username: "Id1", Misc: "Id1"}
+	if err := table.InsertData(obj2); err != nil {
+		fmt.Println("FAIL: Insert with unrelated field matching existing key", err)
+		t.Fail()
+	}
 
+	// the error should say which index and key collided
+	obj3 := testObj{Id: "Id3", Username: "User1", Misc: "y"}
+	err = table.InsertData(obj3)
+	var dupErr sc.ErrDuplicateKey
+	if !errors.As(err, &dupErr) || dupErr.Index != "Username" || dupErr.Key != "User1" {
+		fmt.Println("FAIL: Insert duplicate error", err)
+		t.Fail()
+	}
+
+	// batch where only the last row collides - none of it should go in
+	tableLen := sc.GetTableSize(table)
+	obj4 := testObj{Id: "Id4", Username: "User4"}
+	obj5 := testObj{Id: "Id5", Username: "User5"}
+	obj6 := testObj{Id: "Id1", Username: "User6"}
+	err = table.InsertData(obj4, obj5, obj6)
+	if !errors.As(err, &dupErr) || dupErr.Index != "Id" || dupErr.Key != "Id1" ||
+		sc.GetTableSize(table) != tableLen || table.LookupKey("Id4", "Id") != nil {
+		fmt.Println("FAIL: Partial batch insert", err)
+		t.Fail()
+	}
+
+	// many goroutines inserting rows which all collide on Username, exactly one should win
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if table.InsertData(testObj{Id: fmt.Sprintf("race%d", i), Username: "raceUser"}) == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	winner := table.LookupKey("raceUser", "Username")
+	if wins != 1 || winner == nil || table.LookupKey(winner.(testObj).Id, "Id") != winner ||
+		sc.GetTableSize(table) != tableLen + 1 {
+		fmt.Println("FAIL: Concurrent conflicting inserts", wins, winner)
+		t.Fail()
+	}
 }
 
 func TestCleanTableData(t *testing.T) {
@@ -452,6 +640,292 @@ func TestDropTable(t *testing.T) {
 	}
 }
 
+func TestDelete(t *testing.T) {
+	dbName := "testdb"
+	db := sc.InitDb(dbName)
+
+	tableName := "testTable"
+	table, err := db.AddTable(tableName, "Id", "Username")
+	if err != nil {
+		t.Fail()
+	}
+	type testObj struct {
+		Id string
+		Username string
+		Misc int
+	}
+	obj1 := testObj{Id: "Id1", Username: "User1", Misc: 1}
+	obj2 := testObj{Id: "Id2", Username: "User2", Misc: 2}
+	table.InsertData(obj1, obj2)
+
+	// delete by one index, should be gone from the other too
+	removed, err := table.Delete("Username", "User1")
+	if err != nil || removed != obj1 || sc.GetTableSize(table) != 1 {
+		fmt.Println("FAIL: Delete existing row", removed, err)
+		t.Fail()
+	}
+	for idx := range table.Indexes {
+		if len(table.Indexes[idx].Idx) != 1 {
+			fmt.Println("FAIL: Delete left data in index", idx)
+			t.Fail()
+		}
+	}
+	if table.LookupKey("Id1", "Id") != nil || table.LookupKey("Id2", "Id") != obj2 {
+		fmt.Println("FAIL: Delete removed wrong row")
+		t.Fail()
+	}
+
+	// key which is no longer there
+	if removed, err = table.Delete("Id", "Id1"); err == nil || removed != nil {
+		fmt.Println("FAIL: Delete missing key")
+		t.Fail()
+	}
+
+	// index which doesn't exist
+	if _, err = table.Delete("Misc", 2); err == nil || sc.GetTableSize(table) != 1 {
+		fmt.Println("FAIL: Delete on missing index")
+		t.Fail()
+	}
+
+	// keys are free to be inserted again
+	if err = table.InsertData(obj1); err != nil {
+		fmt.Println("FAIL: Insert after delete", err)
+		t.Fail()
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	dbName := "testdb"
+	db := sc.InitDb(dbName)
+
+	tableName := "testTable"
+	table, err := db.AddTable(tableName, "Id", "Username")
+	if err != nil {
+		t.Fail()
+	}
+	type testObj struct {
+		Id string
+		Username string
+		Misc int
+	}
+	for i := 0; i < 10; i++ {
+		table.InsertData(testObj{Id: fmt.Sprintf("Id%d", i), Username: fmt.Sprintf("User%d", i), Misc: i})
+	}
+
+	// remove the odd ones
+	count := table.DeleteWhere(func(row interface{}) bool {
+		return row.(testObj).Misc % 2 == 1
+	})
+	if count != 5 || sc.GetTableSize(table) != 5 {
+		fmt.Println("FAIL: DeleteWhere count", count, sc.GetTableSize(table))
+		t.Fail()
+	}
+	for i := 0; i < 10; i++ {
+		byId := table.LookupKey(fmt.Sprintf("Id%d", i), "Id")
+		byUser := table.LookupKey(fmt.Sprintf("User%d", i), "Username")
+		if (i % 2 == 1) != (byId == nil) || (i % 2 == 1) != (byUser == nil) {
+			fmt.Println("FAIL: DeleteWhere wrong rows removed", i, byId, byUser)
+			t.Fail()
+		}
+	}
+
+	// nothing matches
+	if count = table.DeleteWhere(func(row interface{}) bool { return false }); count != 0 || sc.GetTableSize(table) != 5 {
+		fmt.Println("FAIL: DeleteWhere nothing matching", count)
+		t.Fail()
+	}
+}
+
+func TestNonUniqueIndex(t *testing.T) {
+	dbName := "testdb"
+	db := sc.InitDb(dbName)
+
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Username": {Unique: true}, "Country": {Unique: false}},
+	}
+	table, err := db.AddTableWithOptions("testTable", opts)
+	if err != nil {
+		t.Fail()
+	}
+	type testObj struct {
+		Id string
+		Username string
+		Country string
+	}
+	obj1 := testObj{Id: "Id1", Username: "User1", Country: "NZ"}
+	obj2 := testObj{Id: "Id2", Username: "User2", Country: "NZ"}
+	obj3 := testObj{Id: "Id3", Username: "User3", Country: "AU"}
+
+	// sharing a non unique key is fine on insert
+	if err = table.InsertData(obj1, obj2, obj3); err != nil || sc.GetTableSize(table) != 3 {
+		fmt.Println("FAIL: Insert rows sharing non unique key", err)
+		t.Fail()
+	}
+	nz := table.LookupAll("Country", "NZ")
+	if len(nz) != 2 || len(table.LookupAll("Country", "AU")) != 1 || len(table.LookupAll("Country", "US")) != 0 {
+		fmt.Println("FAIL: LookupAll non unique", nz)
+		t.Fail()
+	}
+	if row := table.LookupKey("NZ", "Country"); row != obj1 && row != obj2 {
+		fmt.Println("FAIL: LookupKey non unique", row)
+		t.Fail()
+	}
+	if all := table.LookupAll("Id", "Id3"); len(all) != 1 || all[0] != obj3 {
+		fmt.Println("FAIL: LookupAll unique", all)
+		t.Fail()
+	}
+
+	// unique indexes still reject duplicates
+	if err = table.InsertData(testObj{Id: "Id4", Username: "User1", Country: "NZ"}); err == nil {
+		fmt.Println("FAIL: Insert duplicate on unique secondary")
+		t.Fail()
+	}
+
+	// moving a row to another country via its primary key should take it out of the old bucket
+	obj2.Country = "AU"
+	if err = table.SetData(obj2); err != nil || len(table.LookupAll("Country", "NZ")) != 1 ||
+		len(table.LookupAll("Country", "AU")) != 2 || sc.GetTableSize(table) != 3 {
+		fmt.Println("FAIL: SetData move between non unique keys", err)
+		t.Fail()
+	}
+
+	// delete removes from the bucket and drops empty ones
+	if _, err = table.Delete("Id", "Id1"); err != nil || len(table.LookupAll("Country", "NZ")) != 0 {
+		fmt.Println("FAIL: Delete from non unique index", err)
+		t.Fail()
+	}
+	if _, ok := table.Indexes["Country"].Idx["NZ"]; ok {
+		fmt.Println("FAIL: Delete left empty bucket")
+		t.Fail()
+	}
+
+	// can't delete by a non unique index since the key doesn't identify a row
+	if _, err = table.Delete("Country", "AU"); err == nil || sc.GetTableSize(table) != 2 {
+		fmt.Println("FAIL: Delete by non unique index")
+		t.Fail()
+	}
+
+	// update only needs the unique keys to exist
+	obj3.Country = "US"
+	if err = table.UpdateData(obj3); err != nil || len(table.LookupAll("Country", "US")) != 1 {
+		fmt.Println("FAIL: UpdateData non unique key", err)
+		t.Fail()
+	}
+
+	table.CleanTableData()
+	if table.Indexes["Country"].Unique || !table.Indexes["Id"].Unique || sc.GetTableSize(table) != 0 {
+		fmt.Println("FAIL: CleanTableData lost index options")
+		t.Fail()
+	}
+}
+
+func TestCompoundIndex(t *testing.T) {
+	dbName := "testdb"
+	db := sc.InitDb(dbName)
+
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{
+			"Country_City": {Unique: false, Fields: []string{"Country", "City"}},
+			"Username_Country": {Unique: true, Fields: []string{"Username", "Country"}},
+		},
+	}
+	table, err := db.AddTableWithOptions("testTable", opts)
+	if err != nil {
+		t.Fail()
+	}
+	type testObj struct {
+		Id string
+		Username string
+		Country string
+		City string
+	}
+	obj1 := testObj{Id: "Id1", Username: "User1", Country: "NZ", City: "Auckland"}
+	obj2 := testObj{Id: "Id2", Username: "User2", Country: "NZ", City: "Auckland"}
+	obj3 := testObj{Id: "Id3", Username: "User1", Country: "AU", City: "Sydney"}
+	if err = table.InsertData(obj1, obj2, obj3); err != nil {
+		fmt.Println("FAIL: Insert compound index data", err)
+		t.Fail()
+	}
+
+	if len(table.LookupAll("Country_City", sc.Key("NZ", "Auckland"))) != 2 ||
+		len(table.LookupAll("Country_City", sc.Key("AU", "Sydney"))) != 1 ||
+		len(table.LookupAll("Country_City", sc.Key("NZ", "Sydney"))) != 0 {
+		fmt.Println("FAIL: LookupAll on compound index")
+		t.Fail()
+	}
+	if table.LookupKey(sc.Key("User1", "AU"), "Username_Country") != obj3 ||
+		table.LookupKey(sc.Key("AU", "User1"), "Username_Country") != nil {
+		fmt.Println("FAIL: LookupKey on compound index")
+		t.Fail()
+	}
+
+	// uniqueness is on the combination of fields
+	err = table.InsertData(testObj{Id: "Id4", Username: "User1", Country: "NZ", City: "Wellington"})
+	var dupErr sc.ErrDuplicateKey
+	if !errors.As(err, &dupErr) || dupErr.Index != "Username_Country" || dupErr.Key != sc.Key("User1", "NZ") {
+		fmt.Println("FAIL: Insert duplicate compound key", err)
+		t.Fail()
+	}
+	if err = table.InsertData(testObj{Id: "Id4", Username: "User1", Country: "US", City: "Boston"}); err != nil {
+		fmt.Println("FAIL: Insert new compound key", err)
+		t.Fail()
+	}
+
+	// update can move a row's compound unique key, but not onto another row's
+	obj1.City = "Wellington"
+	if err = table.UpdateData(obj1); err != nil || len(table.LookupAll("Country_City", sc.Key("NZ", "Auckland"))) != 1 {
+		fmt.Println("FAIL: UpdateData with compound index", err)
+		t.Fail()
+	}
+	if err = table.UpdateData(testObj{Id: "Id1", Username: "User1", Country: "US", City: "Paris"}); !errors.As(err, &dupErr) ||
+		dupErr.Key != sc.Key("User1", "US") || table.LookupKey(sc.Key("User1", "NZ"), "Username_Country") == nil {
+		fmt.Println("FAIL: UpdateData onto another row's compound key", err)
+		t.Fail()
+	}
+
+	// every field of a compound index is required
+	type testObjNoCity struct {
+		Id string
+		Username string
+		Country string
+	}
+	if sc.HasRequiredIndexes(table, testObjNoCity{}) || table.SetData(testObjNoCity{Id: "Id9"}) == nil {
+		fmt.Println("FAIL: HasRequiredIndexes with compound index")
+		t.Fail()
+	}
+
+	// compound primary key
+	pkOpts := sc.TableOptions{
+		PrimaryKey: "Country_City",
+		Indexes: map[string]sc.IndexOptions{"Country_City": {Unique: true, Fields: []string{"Country", "City"}}},
+	}
+	table2, err := db.AddTableWithOptions("testTable2", pkOpts)
+	if err != nil {
+		t.Fail()
+	}
+	table2.SetData(obj1, obj2, obj3)
+	if sc.GetTableSize(table2) != 3 || table2.LookupKey(sc.Key("NZ", "Auckland"), "Country_City") != obj2 {
+		fmt.Println("FAIL: Compound primary key", sc.GetTableSize(table2))
+		t.Fail()
+	}
+	if _, err = table2.Delete("Country_City", sc.Key("AU", "Sydney")); err != nil || sc.GetTableSize(table2) != 2 {
+		fmt.Println("FAIL: Delete by compound primary key", err)
+		t.Fail()
+	}
+
+	// too many fields
+	tooMany := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Big": {Fields: []string{"A", "B", "C", "D", "E", "F", "G", "H", "I"}}},
+	}
+	if _, err = db.AddTableWithOptions("testTable3", tooMany); err == nil {
+		fmt.Println("FAIL: Compound index with too many fields")
+		t.Fail()
+	}
+}
+
 func TestHasRequiredIndexes(t *testing.T) {
 	dbName := "testdb"
 	db := sc.InitDb(dbName)
@@ -583,21 +1057,20 @@ func TestUsingPointers(t *testing.T) {
 	// TODO this is best as a pointer to save memory??  if so how do we enforce this?
 	table.SetData(&tObj, &tObj2)
 
+	// each row is stored once, so every index hands back the very same pointer
+	if table.LookupKey(objId, "Id") != &tObj || table.LookupKey(objUsername, "Username") != &tObj {
+		fmt.Println("FAIL: TestUsingPointers rows differ between indexes")
+		t.Fail()
+	}
+
 	tObj.Username = "yyy"
 	fmt.Println("tObj", tObj)
-	//table.Indexes["Id"].Idx[objId].Username = "zzz"
-	val := table.Indexes["Id"].Idx[objId]
 	// TODO easily set and retrieve data without crazy . syntax
 	//val.(testObj).Username = "yesss"
 
 	v := table.LookupKey(objId, "Id")
-
-	//val := table.Indexes["Id"]
-	val2 := table.Indexes["Id"].Idx[objId2]
-	//val2 := table.Indexes["Username"]
+	val2 := table.LookupKey(objId2, "Id")
 	fmt.Println("v", v, reflect.TypeOf(v))
-	fmt.Println("table.Indexes['Id'].Idx[objId]", reflect.TypeOf(table.Indexes["Id"].Idx[objId]))
-	fmt.Println("datastruc1", val, "typeof1", reflect.TypeOf(val))
 	fmt.Println("datastruc2", val2, "typeof2", reflect.TypeOf(val2))
 	table.PrettyPrint()
 
@@ -648,27 +1121,5 @@ func TestUpdateData(t *testing.T) {
 }
 
 // TODO
-// Add synchronization to avoid inconsistent data
 // Ensure we are using pointers rather than copies. Write some tests for this
-// Sort data / leaderboard
-//	Need: A. slice/array so can access leaderboard in order a[0], a[1], a[2], etc
-		// this will point at a data struct
-		// type struct Member {
-			// Name string
-			// Score float64
-			// Rank int64 ???  - if i add this then i can have one map of names to scores/ranks to implement zscore and zrank
-
-		// }
-		// we can easily look up the name and score from that
-		//B. way to find rank based on Name or score
-		//C. way to find Members for a given score range (log(n) + m)
-		// OK - zscore - return score of member at a given member name (O(1)) - i would do this using a map of names to scores and is O(1) but requires another data structure
-		// OK - zrange - return elements in given range of ranks (log(n) + m) - i do this using my above array/slice and is O(1)
-		//zrangebyscore - return elements in given range of scores (log(n) + m)
-		// OK - zrank - return rank of member (log(n)) - i would do this using a map of names to ranks which is O(1) but requires another data structure
-
 // Exists command
-// TTL??
-// Compound indexes?? based off a struct eg as done in "Key Types" section of https://blog.golang.org/go-maps-in-action (web counter by country)
-// What about adding indexes after the data has already been added
-//    -could be expensive operation - need to check all data has that index and then add the data there - seems like O(n)
\ No newline at end of file
@@ -0,0 +1,120 @@
+package sc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Output format for Dump
+type DumpFormat int
+
+const (
+	// Each index followed by its keys and rows, one per line, for people to read
+	DumpText DumpFormat = iota
+	// A single JSON object of index name to an object of key to row, or to a list of rows for non unique indexes.
+	// Keys are written with fmt.Sprint since JSON object keys have to be strings
+	DumpJSON
+)
+
+// Write out every index of the table and the rows in it. Indexes and keys are sorted so the output is the same each
+// time for the same data. Expired rows are left out
+func (tbl *Table) Dump(w io.Writer, format DumpFormat) error {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.dump(w, format, tbl.Indexes, time.Now())
+}
+
+// Does the work of Dump for the given indexes, leaving out rows expired at now. Caller must hold the table lock
+func (tbl *Table) dump(w io.Writer, format DumpFormat, indexes map[string]Index, now time.Time) error {
+	switch format {
+	case DumpText:
+		return tbl.dumpText(w, indexes, now)
+	case DumpJSON:
+		return tbl.dumpJSON(w, indexes, now)
+	}
+	return fmt.Errorf("Unknown dump format %d", format)
+}
+
+// Print the table to stdout
+//
+// Deprecated: use Dump, which can write anywhere
+func (tbl *Table) PrettyPrint() {
+	tbl.Dump(os.Stdout, DumpText)
+}
+
+func (tbl *Table) dumpText(w io.Writer, indexes map[string]Index, now time.Time) error {
+	if _, err := fmt.Fprintln(w, "TABLE", tbl.Name); err != nil {
+		return err
+	}
+	for _, name := range indexNames(indexes) {
+		if _, err := fmt.Fprintln(w, "Index:", name); err != nil {
+			return err
+		}
+		keys, rows := tbl.dumpIndex(indexes[name], now)
+		for _, key := range keys {
+			for _, row := range rows[key] {
+				if _, err := fmt.Fprintf(w, "\t%s :: %v\n", key, row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (tbl *Table) dumpJSON(w io.Writer, indexes map[string]Index, now time.Time) error {
+	out := make(map[string]map[string]interface{}, len(indexes))
+	for name, idx := range indexes {
+		keys, rows := tbl.dumpIndex(idx, now)
+		entries := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			if idx.Unique {
+				entries[key] = rows[key][0]
+			} else {
+				entries[key] = rows[key]
+			}
+		}
+		out[name] = entries
+	}
+	// encoding/json sorts map keys itself
+	return json.NewEncoder(w).Encode(struct {
+		Table string `json:"table"`
+		Indexes map[string]map[string]interface{} `json:"indexes"`
+	}{tbl.Name, out})
+}
+
+// The rows in an index which haven't expired by now, by key written with fmt.Sprint, and the keys in order. Rows
+// under the same key are sorted by how they print. Caller must hold the table lock
+func (tbl *Table) dumpIndex(idx Index, now time.Time) ([]string, map[string][]interface{}) {
+	rows := make(map[string][]interface{}, len(idx.Idx))
+	for key := range idx.Idx {
+		for _, rec := range idx.lookup(key) {
+			if !tbl.expired(rec, now) {
+				k := fmt.Sprint(key)
+				rows[k] = append(rows[k], rec.row)
+			}
+		}
+	}
+	keys := make([]string, 0, len(rows))
+	for key, bucket := range rows {
+		keys = append(keys, key)
+		// non unique buckets are maps so come out in any order
+		sort.Slice(bucket, func(i, j int) bool { return fmt.Sprint(bucket[i]) < fmt.Sprint(bucket[j]) })
+	}
+	sort.Strings(keys)
+	return keys, rows
+}
+
+// Index names in order
+func indexNames(indexes map[string]Index) []string {
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
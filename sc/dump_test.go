@@ -0,0 +1,95 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+type dumpTestObj struct {
+	Id int
+	Username string
+	Country string
+}
+
+func TestDump(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Username": {Unique: true}, "Country": {}},
+	}
+	table, _ := db.AddTableWithOptions("users", opts)
+	table.InsertData(dumpTestObj{2, "bob", "NZ"}, dumpTestObj{1, "alice", "NZ"})
+
+	var text bytes.Buffer
+	if err := table.Dump(&text, sc.DumpText); err != nil {
+		fmt.Println("FAIL: TestDump text", err)
+		t.Fail()
+	}
+	expected := "TABLE users\n" +
+		"Index: Country\n\tNZ :: {1 alice NZ}\n\tNZ :: {2 bob NZ}\n" +
+		"Index: Id\n\t1 :: {1 alice NZ}\n\t2 :: {2 bob NZ}\n" +
+		"Index: Username\n\talice :: {1 alice NZ}\n\tbob :: {2 bob NZ}\n"
+	if text.String() != expected {
+		fmt.Println("FAIL: TestDump text output", text.String())
+		t.Fail()
+	}
+
+	var out bytes.Buffer
+	if err := table.Dump(&out, sc.DumpJSON); err != nil {
+		fmt.Println("FAIL: TestDump json", err)
+		t.Fail()
+	}
+	var parsed struct {
+		Table string
+		Indexes map[string]map[string]interface{}
+	}
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil || parsed.Table != "users" ||
+		len(parsed.Indexes["Country"]["NZ"].([]interface{})) != 2 ||
+		parsed.Indexes["Username"]["bob"].(map[string]interface{})["Id"] != 2.0 {
+		fmt.Println("FAIL: TestDump json output", out.String(), err)
+		t.Fail()
+	}
+
+	if err := table.Dump(&out, sc.DumpFormat(9)); err == nil {
+		fmt.Println("FAIL: TestDump unknown format")
+		t.Fail()
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	db := sc.InitDb("testdb", sc.WithLogger(logger))
+	table, _ := db.AddTableWithOptions("users", sc.TableOptions{PrimaryKey: "Id", MaxRows: 1})
+
+	table.InsertData(dumpTestObj{Id: 1})
+	table.InsertData(dumpTestObj{Id: 1})
+	table.UpdateData(dumpTestObj{Id: 1, Username: "alice"})
+	table.InsertData(dumpTestObj{Id: 2})
+
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil || event["table"] != "users" {
+			fmt.Println("FAIL: TestLogger bad event", line)
+			t.Fail()
+		}
+		events = append(events, fmt.Sprint(event["msg"], " ", event["pk"]))
+	}
+	if fmt.Sprint(events) != "[insert 1 conflict <nil> update 1 insert 2 evict 1]" {
+		fmt.Println("FAIL: TestLogger events", events)
+		t.Fail()
+	}
+
+	// nothing is logged, or printed, without a logger
+	quiet, _ := sc.InitDb("testdb").AddTable("users", "Id")
+	if err := quiet.InsertData(dumpTestObj{Id: 1}); err != nil {
+		fmt.Println("FAIL: TestLogger default", err)
+		t.Fail()
+	}
+}
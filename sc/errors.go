@@ -0,0 +1,102 @@
+package sc
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the table methods come wrapped with the details, check for them with errors.Is eg.
+// errors.Is(err, sc.ErrNotFound). ErrDuplicateKey is a type rather than a value so use errors.As for that one
+var (
+	// The row is nil, or isn't a struct, a pointer to one, a map[string]interface{} or a Keyer
+	ErrNotStruct = errors.New("row is not a struct")
+	// The row doesn't have a field one of the table's indexes is keyed on
+	ErrMissingIndexField = errors.New("row is missing an index field")
+	// A key can't be used in a map, eg. it's a slice, or a compound key with a slice in it
+	ErrUnhashableKey = errors.New("key is not hashable")
+	// There's no row with that key, or no index with that name
+	ErrNotFound = errors.New("not found")
+	// The row was written by someone else since the version being compared against, see CompareAndSwap
+	ErrVersionConflict = errors.New("version conflict")
+	// A write was tried in a read only transaction
+	ErrReadOnly = errors.New("read only")
+	// The transaction was used after the function given to Update or View returned
+	ErrTxClosed = errors.New("transaction closed")
+	// The snapshot was read after Release
+	ErrReleased = errors.New("snapshot released")
+	// The db was written to after Close, when it came from Open
+	ErrClosed = errors.New("db closed")
+	// Open found a bad record in the write-ahead log with more of the log after it, so it isn't a torn tail
+	ErrCorrupt = errors.New("log corrupt")
+)
+
+// Returned by InsertData when a row would overwrite an existing key. Index and Key say where the collision was
+type ErrDuplicateKey struct {
+	Index string
+	Key interface{}
+}
+
+func (e ErrDuplicateKey) Error() string {
+	return fmt.Sprintf("Data already exists for key %v in index %s", e.Key, e.Index)
+}
+
+// Make sure a row can go in the table: it is something with fields, has every field the indexes need and all its
+// keys are usable. Caller must hold the table lock
+func (tbl *Table) checkRow(data interface{}) error {
+	if isNil(data) {
+		return errors.Wrapf(ErrNotStruct, "Can't add a nil row to %s", tbl.Name)
+	}
+	fields := fieldsOf(data)
+	if fields.keyer == nil && fields.doc == nil && fields.info == nil {
+		return errors.Wrapf(ErrNotStruct, "Can't add %v of type %T to %s", data, data, tbl.Name)
+	}
+	for name, idx := range tbl.Indexes {
+		for _, f := range idx.Fields {
+			if !fields.has(f) {
+				return errors.Wrapf(ErrMissingIndexField, "Data obj %v doesn't have field %s needed by index %s", data, f, name)
+			}
+		}
+		key, ok := idx.keyOf(fields)
+		if !ok {
+			if name == tbl.pk {
+				return errors.Wrapf(ErrMissingIndexField, "Data obj %v has no primary key %s, a pointer on the way to it is nil", data, name)
+			}
+			continue
+		}
+		if err := idx.checkKey(name, key); err != nil {
+			return err
+		}
+		for _, k := range idx.keysOf(fields) {
+			if !hashable(k) {
+				return errors.Wrapf(ErrUnhashableKey, "Key %v for index %s", k, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Whether a row is nil or a nil pointer, map etc.
+func isNil(data interface{}) bool {
+	if data == nil {
+		return true
+	}
+	val := reflect.ValueOf(data)
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface, reflect.Slice, reflect.Func, reflect.Chan:
+		return val.IsNil()
+	}
+	return false
+}
+
+// Whether key can be used in a map without panicking. Goes by the value rather than the type, so a CompoundKey is
+// only hashable if everything in it is
+func hashable(key interface{}) bool {
+	switch key.(type) {
+	case nil, string, int, int64, int32, uint, uint64, uint32, float64, bool:
+		// the usual keys, without reflect which allocates
+		return true
+	}
+	return reflect.ValueOf(key).Comparable()
+}
@@ -0,0 +1,96 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"errors"
+)
+
+type errTestObj struct {
+	Id int
+	Tags []string
+	Next *errTestObj
+}
+
+// Bad rows and keys come back as errors which can be told apart, and never panic
+func TestErrors(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTable("testTable", "Id")
+	var nilObj *errTestObj
+	var nilAccount *account
+
+	type errCheck struct {
+		name string
+		err error
+		target error
+	}
+	checks := []errCheck{
+		{"int row", table.SetData(5), sc.ErrNotStruct},
+		{"nil row", table.InsertData(nil), sc.ErrNotStruct},
+		{"nil pointer row", table.SetData(nilObj), sc.ErrNotStruct},
+		{"nil Keyer row", table.SetData(nilAccount), sc.ErrNotStruct},
+		{"missing field", table.InsertData(struct{ Name string }{"x"}), sc.ErrMissingIndexField},
+		{"update missing row", table.UpdateData(errTestObj{Id: 1}), sc.ErrNotFound},
+		{"drop missing index", table.DropIndex("Nope"), sc.ErrNotFound},
+	}
+	_, err := table.Delete("Id", 1)
+	checks = append(checks, errCheck{"delete missing row", err, sc.ErrNotFound})
+	_, err = table.Delete("Nope", 1)
+	checks = append(checks, errCheck{"delete from missing index", err, sc.ErrNotFound})
+	_, err = table.Delete("Id", []int{1})
+	checks = append(checks, errCheck{"delete unhashable key", err, sc.ErrUnhashableKey})
+
+	for _, check := range checks {
+		if !errors.Is(check.err, check.target) {
+			fmt.Println("FAIL: TestErrors", check.name, check.err)
+			t.Fail()
+		}
+	}
+
+	// slices can't be keys, except in a multi value index
+	tags, _ := db.AddTable("tags", "Id", "Tags")
+	if err = tags.InsertData(errTestObj{Id: 1, Tags: []string{"a"}}); !errors.Is(err, sc.ErrUnhashableKey) {
+		fmt.Println("FAIL: TestErrors slice key", err)
+		t.Fail()
+	}
+	multi, _ := db.AddTableWithOptions("multi", sc.TableOptions{PrimaryKey: "Id", Indexes: map[string]sc.IndexOptions{"Tags": {Multi: true}}})
+	if err = multi.InsertData(errTestObj{Id: 1, Tags: []string{"a"}}); err != nil {
+		fmt.Println("FAIL: TestErrors multi", err)
+		t.Fail()
+	}
+	compound, _ := db.AddTableWithOptions("compound", sc.TableOptions{PrimaryKey: "Id", Indexes: map[string]sc.IndexOptions{"Id_Tags": {Fields: []string{"Id", "Tags"}}}})
+	if err = compound.InsertData(errTestObj{Id: 1, Tags: []string{"a"}}); !errors.Is(err, sc.ErrUnhashableKey) {
+		fmt.Println("FAIL: TestErrors compound slice key", err)
+		t.Fail()
+	}
+	// a nil pointer on the way to the primary key
+	byNext, _ := db.AddTable("byNext", "Next.Id")
+	if err = byNext.InsertData(errTestObj{Id: 1}); !errors.Is(err, sc.ErrMissingIndexField) {
+		fmt.Println("FAIL: TestErrors nil primary key", err)
+		t.Fail()
+	}
+
+	// duplicates are still their own type
+	table.InsertData(errTestObj{Id: 1})
+	var dupErr sc.ErrDuplicateKey
+	if err = table.InsertData(errTestObj{Id: 1}); !errors.As(err, &dupErr) || dupErr.Key != 1 {
+		fmt.Println("FAIL: TestErrors duplicate", err)
+		t.Fail()
+	}
+
+	// lookups with keys that can't be hashed just don't find anything
+	if table.LookupKey([]int{1}, "Id") != nil || len(table.LookupAll("Id", map[string]int{})) != 0 ||
+		table.LookupKey(sc.Key(1, []int{1}), "Id") != nil {
+		fmt.Println("FAIL: TestErrors unhashable lookup")
+		t.Fail()
+	}
+	if sc.HasRequiredIndexes(table, nilAccount) || sc.HasRequiredIndexes(table, 5) {
+		fmt.Println("FAIL: TestErrors HasRequiredIndexes")
+		t.Fail()
+	}
+	if sc.GetTableSize(table) != 1 {
+		fmt.Println("FAIL: TestErrors bad rows went in", sc.GetTableSize(table))
+		t.Fail()
+	}
+}
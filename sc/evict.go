@@ -0,0 +1,347 @@
+package sc
+
+import (
+	"container/heap"
+	"container/list"
+	"reflect"
+	"time"
+)
+
+// Rough cost in bytes of a row's entry in each index, on top of the row itself. Only used for MaxBytes
+const indexEntryOverhead = 64
+
+// How deep approxSize follows pointers, slices etc. before it stops counting, so cyclic data can't hang it
+const maxSizeDepth = 8
+
+// Keeps track of rows in a bounded table and picks which one to evict when it is over its limits.
+// Rows are identified by primary key. Added, Removed and Victim are called with the table write lock held, Accessed
+// may be called by several readers at once but never at the same time as another Evictor method on the same table.
+// Implement this to plug in a policy other than the built in LRU, LFU, FIFO and Random
+type Evictor interface {
+	// A new row went into the table
+	Added(pk interface{})
+	// A row was read, or overwritten in place
+	Accessed(pk interface{})
+	// A row left the table for any reason, including being evicted
+	Removed(pk interface{})
+	// The row to evict next. ok is false if there are no rows
+	Victim() (pk interface{}, ok bool)
+}
+
+// Makes a new Evictor for a table, eg. sc.LRU
+type EvictionPolicy func() Evictor
+
+// Why a row was removed without anybody deleting it
+type EvictReason int
+
+const (
+	// The table was over TableOptions.MaxRows
+	ReasonMaxRows EvictReason = iota
+	// The table was over TableOptions.MaxBytes
+	ReasonMaxBytes
+	// The row's TTL ran out
+	ReasonExpired
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case ReasonMaxRows:
+		return "max rows"
+	case ReasonMaxBytes:
+		return "max bytes"
+	case ReasonExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+type evictedRow struct {
+	row interface{}
+	reason EvictReason
+}
+
+// Release the table write lock, then tell OnEvict about anything evicted while it was held. Callbacks run outside
+// the lock so they are free to use the table. Changes the write didn't commit itself, eg. because it failed part way
+// after removing expired rows, are logged first
+func (tbl *Table) unlock() {
+	tbl.commit(nil)
+	evicted := tbl.evicted
+	tbl.evicted = nil
+	tbl.mu.Unlock()
+	for _, e := range evicted {
+		tbl.onEvict(e.row, e.reason)
+	}
+}
+
+// Remove a row from the table and queue it up for OnEvict. Caller must hold the table write lock
+func (tbl *Table) evict(rec *record, reason EvictReason) {
+	tbl.deleteRecord(rec)
+	tbl.db.logger.Debug("evict", "table", tbl.Name, "pk", rec.pk, "reason", reason.String())
+	if tbl.onEvict != nil {
+		tbl.evicted = append(tbl.evicted, evictedRow{row: rec.row, reason: reason})
+	}
+}
+
+// Evict rows until the table is back under MaxRows and MaxBytes. Expired rows go first since they are dead anyway,
+// after that the evictor decides. Caller must hold the table write lock
+func (tbl *Table) enforceLimits() {
+	if tbl.evictor == nil || !tbl.overLimits() {
+		return
+	}
+	tbl.removeExpired(time.Now())
+	for tbl.overLimits() {
+		pk, ok := tbl.evictor.Victim()
+		if !ok {
+			return
+		}
+		rec, ok := tbl.Indexes[tbl.pk].get(pk)
+		if !ok {
+			// evictor is out of step with the table, forget about the row rather than looping on it
+			tbl.evictor.Removed(pk)
+			continue
+		}
+		reason := ReasonMaxBytes
+		if tbl.maxRows > 0 && len(tbl.rows) > tbl.maxRows {
+			reason = ReasonMaxRows
+		}
+		tbl.evict(rec, reason)
+	}
+}
+
+func (tbl *Table) overLimits() bool {
+	return (tbl.maxRows > 0 && len(tbl.rows) > tbl.maxRows) || (tbl.maxBytes > 0 && tbl.bytes > tbl.maxBytes)
+}
+
+// Tell the evictor about a row which was just written and update the size accounting.
+// replaced means the row overwrote an older version of itself. Caller must hold the table write lock
+func (tbl *Table) track(rec *record, replaced bool) {
+	if tbl.evictor == nil {
+		return
+	}
+	if tbl.maxBytes > 0 {
+		size := approxSize(rec.row) + int64(len(tbl.Indexes)) * indexEntryOverhead
+		tbl.bytes += size - rec.size
+		rec.size = size
+	}
+	if replaced {
+		tbl.evictor.Accessed(rec.pk)
+	} else {
+		tbl.evictor.Added(rec.pk)
+	}
+}
+
+// Forget about a row which left the table. Caller must hold the table write lock
+func (tbl *Table) untrack(rec *record) {
+	if tbl.evictor == nil {
+		return
+	}
+	tbl.bytes -= rec.size
+	tbl.evictor.Removed(rec.pk)
+}
+
+// Tell the evictor a row was read. Caller must hold at least the table read lock
+func (tbl *Table) accessed(rec *record) {
+	if tbl.evictor == nil {
+		return
+	}
+	tbl.evictMu.Lock()
+	tbl.evictor.Accessed(rec.pk)
+	tbl.evictMu.Unlock()
+}
+
+// Rough number of bytes a row takes up, counting what it points to as well as the struct itself.
+// Shared data is counted each time it is reached and anything nested deeper than maxSizeDepth is ignored
+func approxSize(data interface{}) int64 {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() {
+		return 0
+	}
+	return int64(v.Type().Size()) + indirectSize(v, 0)
+}
+
+// Bytes v refers to outside of its own inline storage
+func indirectSize(v reflect.Value, depth int) int64 {
+	if depth > maxSizeDepth {
+		return 0
+	}
+	var size int64
+	switch v.Kind() {
+	case reflect.String:
+		size = int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return 0
+		}
+		size = int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), depth + 1)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), depth + 1)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			size += indirectSize(v.Field(i), depth + 1)
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		elem := v.Elem()
+		size = int64(elem.Type().Size()) + indirectSize(elem, depth + 1)
+	case reflect.Map:
+		if v.IsNil() {
+			return 0
+		}
+		entry := int64(v.Type().Key().Size() + v.Type().Elem().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			size += entry + indirectSize(iter.Key(), depth + 1) + indirectSize(iter.Value(), depth + 1)
+		}
+	}
+	return size
+}
+
+// Evicts the row written longest ago. Reads don't count
+func FIFO() Evictor {
+	return &listEvictor{order: list.New(), elems: make(map[interface{}]*list.Element)}
+}
+
+// Evicts the row which was read or written longest ago
+func LRU() Evictor {
+	return &listEvictor{order: list.New(), elems: make(map[interface{}]*list.Element), moveOnAccess: true}
+}
+
+// Evicts the row which has been read or written the fewest times, oldest first when there's a tie
+func LFU() Evictor {
+	return &lfuEvictor{entries: make(map[interface{}]*lfuEntry)}
+}
+
+// Evicts any row, picked using Go's randomised map iteration
+func Random() Evictor {
+	return &randomEvictor{rows: make(map[interface{}]struct{})}
+}
+
+// Rows in the order they'll be evicted, oldest at the front
+type listEvictor struct {
+	order *list.List
+	elems map[interface{}]*list.Element
+	moveOnAccess bool
+}
+
+func (e *listEvictor) Added(pk interface{}) {
+	e.elems[pk] = e.order.PushBack(pk)
+}
+
+func (e *listEvictor) Accessed(pk interface{}) {
+	if elem, ok := e.elems[pk]; ok && e.moveOnAccess {
+		e.order.MoveToBack(elem)
+	}
+}
+
+func (e *listEvictor) Removed(pk interface{}) {
+	if elem, ok := e.elems[pk]; ok {
+		e.order.Remove(elem)
+		delete(e.elems, pk)
+	}
+}
+
+func (e *listEvictor) Victim() (interface{}, bool) {
+	if front := e.order.Front(); front != nil {
+		return front.Value, true
+	}
+	return nil, false
+}
+
+type lfuEntry struct {
+	pk interface{}
+	uses int
+	// when it was last used, to break ties between rows with the same number of uses
+	tick uint64
+	index int
+}
+
+// Min heap of rows by number of uses
+type lfuEvictor struct {
+	entries map[interface{}]*lfuEntry
+	heap lfuHeap
+	tick uint64
+}
+
+func (e *lfuEvictor) Added(pk interface{}) {
+	e.tick++
+	entry := &lfuEntry{pk: pk, uses: 1, tick: e.tick}
+	e.entries[pk] = entry
+	heap.Push(&e.heap, entry)
+}
+
+func (e *lfuEvictor) Accessed(pk interface{}) {
+	if entry, ok := e.entries[pk]; ok {
+		e.tick++
+		entry.uses++
+		entry.tick = e.tick
+		heap.Fix(&e.heap, entry.index)
+	}
+}
+
+func (e *lfuEvictor) Removed(pk interface{}) {
+	if entry, ok := e.entries[pk]; ok {
+		heap.Remove(&e.heap, entry.index)
+		delete(e.entries, pk)
+	}
+}
+
+func (e *lfuEvictor) Victim() (interface{}, bool) {
+	if len(e.heap) == 0 {
+		return nil, false
+	}
+	return e.heap[0].pk, true
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].uses != h[j].uses {
+		return h[i].uses < h[j].uses
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	entry := x.(*lfuEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old) - 1]
+	*h = old[:len(old) - 1]
+	return entry
+}
+
+type randomEvictor struct {
+	rows map[interface{}]struct{}
+}
+
+func (e *randomEvictor) Added(pk interface{}) {
+	e.rows[pk] = struct{}{}
+}
+
+func (e *randomEvictor) Accessed(pk interface{}) {}
+
+func (e *randomEvictor) Removed(pk interface{}) {
+	delete(e.rows, pk)
+}
+
+func (e *randomEvictor) Victim() (interface{}, bool) {
+	for pk := range e.rows {
+		return pk, true
+	}
+	return nil, false
+}
@@ -0,0 +1,248 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"strings"
+	"time"
+	"sync"
+)
+
+type evictTestObj struct {
+	Id int
+	Username string
+	Payload string
+}
+
+func evictTestRow(i int) evictTestObj {
+	return evictTestObj{Id: i, Username: fmt.Sprintf("user%d", i)}
+}
+
+// Table limited to 3 rows which records everything evicted
+func newBoundedTable(t *testing.T, policy sc.EvictionPolicy) (*sc.Table, *[]int) {
+	evicted := &[]int{}
+	// callbacks run outside the table lock so they can race each other
+	var mu sync.Mutex
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Username": {Unique: true}},
+		MaxRows: 3,
+		Eviction: policy,
+		OnEvict: func(row interface{}, reason sc.EvictReason) {
+			if reason != sc.ReasonMaxRows {
+				t.Errorf("FAIL: eviction reason %s", reason)
+			}
+			mu.Lock()
+			*evicted = append(*evicted, row.(evictTestObj).Id)
+			mu.Unlock()
+		},
+	}
+	table, err := db.AddTableWithOptions("bounded", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table, evicted
+}
+
+// Every index should hold exactly the expected rows
+func checkRows(table *sc.Table, ids... int) bool {
+	if sc.GetTableSize(table) != len(ids) || len(table.Indexes["Username"].Idx) != len(ids) {
+		return false
+	}
+	for _, id := range ids {
+		if table.LookupKey(id, "Id") == nil || table.LookupKey(fmt.Sprintf("user%d", id), "Username") == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEvictLRU(t *testing.T) {
+	table, evicted := newBoundedTable(t, sc.LRU)
+	table.InsertData(evictTestRow(1), evictTestRow(2), evictTestRow(3))
+	// 1 is now the most recently used
+	table.LookupKey(1, "Id")
+	table.InsertData(evictTestRow(4))
+	if len(*evicted) != 1 || (*evicted)[0] != 2 || !checkRows(table, 1, 3, 4) {
+		fmt.Println("FAIL: TestEvictLRU", *evicted)
+		t.Fail()
+	}
+	// overwriting counts as a use too
+	table.SetData(evictTestRow(3))
+	table.InsertData(evictTestRow(5))
+	if len(*evicted) != 2 || (*evicted)[1] != 1 || !checkRows(table, 3, 4, 5) {
+		fmt.Println("FAIL: TestEvictLRU overwrite", *evicted)
+		t.Fail()
+	}
+}
+
+func TestEvictFIFO(t *testing.T) {
+	table, evicted := newBoundedTable(t, sc.FIFO)
+	table.InsertData(evictTestRow(1), evictTestRow(2), evictTestRow(3))
+	// reads don't matter, 1 still goes first
+	table.LookupKey(1, "Id")
+	table.InsertData(evictTestRow(4), evictTestRow(5))
+	if len(*evicted) != 2 || (*evicted)[0] != 1 || (*evicted)[1] != 2 || !checkRows(table, 3, 4, 5) {
+		fmt.Println("FAIL: TestEvictFIFO", *evicted)
+		t.Fail()
+	}
+}
+
+func TestEvictLFU(t *testing.T) {
+	table, evicted := newBoundedTable(t, sc.LFU)
+	table.InsertData(evictTestRow(1), evictTestRow(2), evictTestRow(3))
+	for i := 0; i < 3; i++ {
+		table.LookupKey(1, "Id")
+		table.LookupAll("Username", "user3")
+	}
+	// 2 and the new 4 have only been used once, 2 is older so it goes first, then 4 is older than 5
+	table.InsertData(evictTestRow(4))
+	table.InsertData(evictTestRow(5))
+	if len(*evicted) != 2 || (*evicted)[0] != 2 || (*evicted)[1] != 4 || !checkRows(table, 1, 3, 5) {
+		fmt.Println("FAIL: TestEvictLFU", *evicted)
+		t.Fail()
+	}
+}
+
+func TestEvictRandom(t *testing.T) {
+	table, evicted := newBoundedTable(t, sc.Random)
+	for i := 0; i < 20; i++ {
+		table.InsertData(evictTestRow(i))
+	}
+	if len(*evicted) != 17 || sc.GetTableSize(table) != 3 || len(table.Indexes["Username"].Idx) != 3 {
+		fmt.Println("FAIL: TestEvictRandom", *evicted)
+		t.Fail()
+	}
+	for _, id := range *evicted {
+		if table.LookupKey(id, "Id") != nil {
+			fmt.Println("FAIL: TestEvictRandom evicted row still there", id)
+			t.Fail()
+		}
+	}
+}
+
+func TestEvictMaxBytes(t *testing.T) {
+	db := sc.InitDb("testdb")
+	var reasons []sc.EvictReason
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		MaxBytes: 10000,
+		OnEvict: func(row interface{}, reason sc.EvictReason) {
+			reasons = append(reasons, reason)
+		},
+	}
+	table, _ := db.AddTableWithOptions("bounded", opts)
+
+	// each row is over 1KB so no more than 9 fit
+	for i := 0; i < 20; i++ {
+		table.InsertData(evictTestObj{Id: i, Payload: strings.Repeat("x", 1024)})
+	}
+	size := sc.GetTableSize(table)
+	if size == 0 || size > 9 || len(reasons) != 20 - size || reasons[0] != sc.ReasonMaxBytes {
+		fmt.Println("FAIL: TestEvictMaxBytes", size, reasons)
+		t.Fail()
+	}
+	// default policy is LRU so the newest rows are the ones left
+	if table.LookupKey(19, "Id") == nil || table.LookupKey(0, "Id") != nil {
+		fmt.Println("FAIL: TestEvictMaxBytes wrong rows evicted")
+		t.Fail()
+	}
+	// freeing space by deleting means the next insert doesn't need to evict
+	table.Delete("Id", 19)
+	table.InsertData(evictTestObj{Id: 100, Payload: strings.Repeat("x", 1024)})
+	if len(reasons) != 20 - size || sc.GetTableSize(table) != size {
+		fmt.Println("FAIL: TestEvictMaxBytes after delete", reasons)
+		t.Fail()
+	}
+}
+
+// Growing a row with an update counts against MaxBytes the same as inserting one
+func TestEvictMaxBytesUpdate(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTableWithOptions("bounded", sc.TableOptions{PrimaryKey: "Id", MaxBytes: 10000})
+	for i := 0; i < 5; i++ {
+		table.InsertData(evictTestObj{Id: i, Payload: strings.Repeat("x", 1024)})
+	}
+	if size := sc.GetTableSize(table); size != 5 {
+		fmt.Println("FAIL: TestEvictMaxBytesUpdate evicted on insert", size)
+		t.Fail()
+	}
+	table.UpdateData(evictTestObj{Id: 4, Payload: strings.Repeat("x", 6000)})
+	size := sc.GetTableSize(table)
+	if size == 5 || table.LookupKey(4, "Id") == nil || table.LookupKey(0, "Id") != nil {
+		fmt.Println("FAIL: TestEvictMaxBytesUpdate UpdateData", size)
+		t.Fail()
+	}
+	table.Patch("Id", 3, map[string]interface{}{"Payload": strings.Repeat("x", 6000)})
+	if sc.GetTableSize(table) >= size || table.LookupKey(3, "Id") == nil || table.LookupKey(4, "Id") != nil {
+		fmt.Println("FAIL: TestEvictMaxBytesUpdate Patch", sc.GetTableSize(table))
+		t.Fail()
+	}
+}
+
+func TestOnEvictExpired(t *testing.T) {
+	db := sc.InitDb("testdb")
+	defer db.Close()
+	var evicted []interface{}
+	var table *sc.Table
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		MaxRows: 2,
+		OnEvict: func(row interface{}, reason sc.EvictReason) {
+			if reason != sc.ReasonExpired {
+				t.Errorf("FAIL: eviction reason %s", reason)
+			}
+			// runs outside the lock so using the table here mustn't deadlock
+			sc.GetTableSize(table)
+			evicted = append(evicted, row)
+		},
+	}
+	table, _ = db.AddTableWithOptions("bounded", opts)
+
+	table.InsertDataWithTTL(10 * time.Millisecond, evictTestRow(1))
+	table.InsertData(evictTestRow(2))
+	time.Sleep(20 * time.Millisecond)
+	// the expired row makes room before any live row is evicted
+	table.InsertData(evictTestRow(3))
+	if len(evicted) != 1 || evicted[0] != evictTestRow(1) || sc.GetTableSize(table) != 2 {
+		fmt.Println("FAIL: TestOnEvictExpired", evicted)
+		t.Fail()
+	}
+	// deleting isn't an eviction
+	table.Delete("Id", 2)
+	table.InsertDataWithTTL(10 * time.Millisecond, evictTestRow(4))
+	time.Sleep(20 * time.Millisecond)
+	if table.RemoveExpired(time.Now()) != 1 || len(evicted) != 2 {
+		fmt.Println("FAIL: TestOnEvictExpired RemoveExpired", evicted)
+		t.Fail()
+	}
+}
+
+// Run with -race. Readers update the LRU order while only holding the read lock
+func TestEvictConcurrent(t *testing.T) {
+	table, _ := newBoundedTable(t, sc.LRU)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				table.SetData(evictTestRow(g * 100 + i))
+			}
+		}(g)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				table.LookupKey(g * 100 + i, "Id")
+				table.LookupAll("Username", fmt.Sprintf("user%d", g * 100 + i))
+			}
+		}(g)
+	}
+	wg.Wait()
+	if sc.GetTableSize(table) != 3 || len(table.Indexes["Username"].Idx) != 3 {
+		fmt.Println("FAIL: TestEvictConcurrent", sc.GetTableSize(table))
+		t.Fail()
+	}
+}
@@ -0,0 +1,33 @@
+package sc
+
+import "time"
+
+// Hooks for the external sc_test package to tweak internals
+
+// Lets tests use small chunks so background index builds take several turns without needing huge tables
+func SetBackfillChunk(n int) (restore func()) {
+	old := backfillChunk
+	backfillChunk = n
+	return func() { backfillChunk = old }
+}
+
+// Lets tests run the janitor often enough to see it work. Must be called before the db's first TTL row is set
+func SetJanitorInterval(d time.Duration) (restore func()) {
+	old := janitorInterval
+	janitorInterval = d
+	return func() { janitorInterval = old }
+}
+
+// Rows actually stored in the table, including expired ones nobody has removed yet
+func StoredRows(tbl *Table) int {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return len(tbl.rows)
+}
+
+// Old row versions the table is keeping for its live snapshots
+func SnapshotHistory(tbl *Table) int {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return len(tbl.history)
+}
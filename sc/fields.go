@@ -0,0 +1,155 @@
+package sc
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Layout of a struct type, ie. where each field used by an index is, worked out once per type and shared by every
+// table
+type typeInfo struct {
+	typ reflect.Type
+	// fieldPath by field name, filled in the first time each name is asked for
+	paths sync.Map
+}
+
+// Where a field is in a struct, as a list of field indexes to follow from the outer struct down. Dotted names like
+// Address.PostCode and fields promoted from embedded structs both go through several structs to get there
+type fieldPath struct {
+	index []int
+	// false if the type has no exported field by that name
+	ok bool
+}
+
+// Rows can implement Keyer to hand over their own index keys instead of having them read by reflection, eg. to
+// index unexported fields or computed values
+type Keyer interface {
+	// The value of the named field, as listed in IndexOptions.Fields or defaulting to the index name.
+	// ok is false if the row has no value for it
+	IndexKey(name string) (key interface{}, ok bool)
+}
+
+// *typeInfo by reflect.Type
+var typeCache sync.Map
+
+// The layout of typ, working it out the first time the type is seen. typ must be a struct type
+func infoFor(typ reflect.Type) *typeInfo {
+	if info, ok := typeCache.Load(typ); ok {
+		return info.(*typeInfo)
+	}
+	// another goroutine may have got there first, use whichever went in so everyone shares one
+	info, _ := typeCache.LoadOrStore(typ, &typeInfo{typ: typ})
+	return info.(*typeInfo)
+}
+
+// Where the named field is. The name can be a field of the struct, one promoted from an embedded struct, or a dotted
+// path through nested structs or pointers to them eg. Address.PostCode
+func (info *typeInfo) path(name string) fieldPath {
+	if path, ok := info.paths.Load(name); ok {
+		return path.(fieldPath)
+	}
+	path := resolvePath(info.typ, name)
+	info.paths.Store(name, path)
+	return path
+}
+
+func resolvePath(typ reflect.Type, name string) fieldPath {
+	var index []int
+	for _, part := range strings.Split(name, ".") {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return fieldPath{}
+		}
+		// unexported fields can't be read through reflection so can't be indexed
+		field, ok := typ.FieldByName(part)
+		if !ok || !field.IsExported() {
+			return fieldPath{}
+		}
+		index = append(index, field.Index...)
+		typ = field.Type
+	}
+	return fieldPath{index: index, ok: true}
+}
+
+// A row's field values, read on demand. Rows which are a Keyer are asked for them, map[string]interface{} rows
+// are looked up and structs use the cached layout of their type rather than reflecting over every field
+type fieldValues struct {
+	val reflect.Value
+	info *typeInfo
+	keyer Keyer
+	doc map[string]interface{}
+}
+
+// Get at the fields of a Keyer, a map[string]interface{}, or a struct or pointer to one. Anything else has no fields
+func fieldsOf(data interface{}) fieldValues {
+	if keyer, ok := data.(Keyer); ok && !isNil(data) {
+		return fieldValues{keyer: keyer}
+	}
+	if doc, ok := data.(map[string]interface{}); ok {
+		return fieldValues{doc: doc}
+	}
+	val := reflect.Indirect(reflect.ValueOf(data))
+	if val.Kind() != reflect.Struct {
+		return fieldValues{}
+	}
+	return fieldValues{val: val, info: infoFor(val.Type())}
+}
+
+// Value of the named field. ok is false if the row doesn't have it, including when a pointer on the way to it is nil
+func (f fieldValues) value(name string) (value interface{}, ok bool) {
+	if f.keyer != nil {
+		return f.keyer.IndexKey(name)
+	}
+	if f.doc != nil {
+		return docValue(f.doc, name)
+	}
+	if f.info == nil {
+		return nil, false
+	}
+	path := f.info.path(name)
+	if !path.ok {
+		return nil, false
+	}
+	v := f.val
+	for _, i := range path.index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v.Interface(), true
+}
+
+// Whether the row's type has the named field. It may still have no value for it if there's a nil pointer on the way.
+// Keyers and maps have no fixed type so they have the field if they have a value for it
+func (f fieldValues) has(name string) bool {
+	if f.keyer != nil || f.doc != nil {
+		_, ok := f.value(name)
+		return ok
+	}
+	return f.info != nil && f.info.path(name).ok
+}
+
+// Look a field up in a map row. A dotted name which isn't a key itself goes through nested maps, the way a JSON
+// document decodes, so Address.PostCode finds doc["Address"]["PostCode"]
+func docValue(doc map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := doc[name]; ok {
+		return value, true
+	}
+	parts := strings.Split(name, ".")
+	for _, part := range parts[:len(parts) - 1] {
+		nested, ok := doc[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		doc = nested
+	}
+	value, ok := doc[parts[len(parts) - 1]]
+	return value, ok
+}
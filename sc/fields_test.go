@@ -0,0 +1,216 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"sync"
+	"strings"
+	"encoding/json"
+)
+
+type withUnexported struct {
+	Id int
+	secret string
+}
+
+// Rows can have unexported fields as long as no index needs them
+func TestUnexportedFields(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTable("testTable", "Id")
+	if err := table.InsertData(withUnexported{Id: 1, secret: "x"}); err != nil || table.LookupKey(1, "Id") == nil {
+		fmt.Println("FAIL: TestUnexportedFields", err)
+		t.Fail()
+	}
+	bad, _ := db.AddTable("badTable", "secret")
+	if err := bad.InsertData(withUnexported{Id: 1, secret: "x"}); err == nil {
+		fmt.Println("FAIL: TestUnexportedFields indexed an unexported field")
+		t.Fail()
+	}
+}
+
+// Run with -race. Lots of tables seeing a type for the first time at once all share one cached layout
+func TestFieldCacheConcurrent(t *testing.T) {
+	type firstSeen struct {
+		Id int
+		Name string
+	}
+	db := sc.InitDb("testdb")
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		table, _ := db.AddTable(fmt.Sprintf("t%d", g), "Id", "Name")
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			if err := table.InsertData(firstSeen{Id: g, Name: "n"}); err != nil {
+				t.Errorf("FAIL: TestFieldCacheConcurrent %s", err)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+type address struct {
+	Street string
+	PostCode string
+}
+
+type audit struct {
+	CreatedBy string `sc:"index"`
+}
+
+type customer struct {
+	audit
+	Id int `sc:"pk"`
+	Home address
+	Work *address
+}
+
+func TestNestedFields(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{
+			"PostCode": {Fields: []string{"Home.PostCode"}},
+			"WorkPostCode": {Unique: true, Fields: []string{"Work.PostCode"}},
+			"CreatedBy": {},
+		},
+	}
+	customers, err := sc.NewTableWithOptions[customer](db, "customers", opts)
+	if err != nil {
+		fmt.Println("FAIL: TestNestedFields", err)
+		t.FailNow()
+	}
+	c1 := customer{audit{"admin"}, 1, address{"1 Queen St", "1010"}, &address{"2 King St", "6011"}}
+	c2 := customer{audit{"admin"}, 2, address{"3 Queen St", "1010"}, nil}
+	c3 := customer{audit{"bot"}, 3, address{"4 Queen St", "2010"}, nil}
+	if err = customers.Insert(c1, c2, c3); err != nil {
+		fmt.Println("FAIL: TestNestedFields insert", err)
+		t.FailNow()
+	}
+	if len(customers.GetAll("PostCode", "1010")) != 2 || len(customers.GetAll("CreatedBy", "admin")) != 2 {
+		fmt.Println("FAIL: TestNestedFields nested or embedded lookup")
+		t.Fail()
+	}
+	// the nil Work pointers mean c2 and c3 aren't in that index at all, so they don't collide with each other
+	if row, ok := customers.Get("WorkPostCode", "6011"); !ok || row.Id != 1 || len(customers.Table().Indexes["WorkPostCode"].Idx) != 1 {
+		fmt.Println("FAIL: TestNestedFields nil pointer")
+		t.Fail()
+	}
+	// rows which do have the key still have to be unique
+	if err = customers.Insert(customer{Id: 4, Work: &address{PostCode: "6011"}}); err == nil {
+		fmt.Println("FAIL: TestNestedFields unique nested key not enforced")
+		t.Fail()
+	}
+	customers.Delete("Id", 1)
+	if _, ok := customers.Get("WorkPostCode", "6011"); ok {
+		fmt.Println("FAIL: TestNestedFields delete")
+		t.Fail()
+	}
+
+	// embedded tags are picked up too
+	tagged, err := sc.TableOptionsFor[customer]()
+	if err != nil || tagged.PrimaryKey != "Id" || len(tagged.Indexes) != 2 {
+		fmt.Println("FAIL: TestNestedFields tags", tagged, err)
+		t.Fail()
+	}
+
+	if _, err = sc.NewTableWithOptions[customer](db, "bad", sc.TableOptions{PrimaryKey: "Home.Nope"}); err == nil {
+		fmt.Println("FAIL: TestNestedFields path which doesn't exist")
+		t.Fail()
+	}
+	// a primary key behind a nil pointer is an error rather than a panic
+	byWork, _ := db.AddTableWithOptions("byWork", sc.TableOptions{PrimaryKey: "Work.PostCode"})
+	if err = byWork.InsertData(c3); err == nil || sc.GetTableSize(byWork) != 0 {
+		fmt.Println("FAIL: TestNestedFields nil primary key")
+		t.Fail()
+	}
+}
+
+// Keeps its fields to itself but hands over index keys
+type account struct {
+	id int
+	email string
+}
+
+func (a *account) IndexKey(name string) (interface{}, bool) {
+	switch name {
+	case "Id":
+		return a.id, true
+	case "Email":
+		return a.email, a.email != ""
+	case "Domain":
+		return a.email[strings.Index(a.email, "@") + 1:], strings.Contains(a.email, "@")
+	}
+	return nil, false
+}
+
+func TestKeyer(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Email": {Unique: true}, "Domain": {}},
+	}
+	accounts, err := sc.NewTableWithOptions[*account](db, "accounts", opts)
+	if err != nil {
+		fmt.Println("FAIL: TestKeyer", err)
+		t.FailNow()
+	}
+	a1 := &account{1, "a@example.com"}
+	a2 := &account{2, "b@example.com"}
+	if err = accounts.Insert(a1, a2); err != nil {
+		fmt.Println("FAIL: TestKeyer insert", err)
+		t.FailNow()
+	}
+	if row, ok := accounts.Get("Email", "b@example.com"); !ok || row != a2 || len(accounts.GetAll("Domain", "example.com")) != 2 {
+		fmt.Println("FAIL: TestKeyer lookup")
+		t.Fail()
+	}
+	if err = accounts.Insert(&account{3, "a@example.com"}); err == nil {
+		fmt.Println("FAIL: TestKeyer uniqueness")
+		t.Fail()
+	}
+	// a Keyer without a value for a required field doesn't go in
+	if err = accounts.Insert(&account{id: 4}); err == nil {
+		fmt.Println("FAIL: TestKeyer missing key")
+		t.Fail()
+	}
+	accounts.Delete("Id", 1)
+	if _, ok := accounts.Get("Email", "a@example.com"); ok || len(accounts.GetAll("Domain", "example.com")) != 1 {
+		fmt.Println("FAIL: TestKeyer delete")
+		t.Fail()
+	}
+}
+
+func TestMapRows(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "id",
+		Indexes: map[string]sc.IndexOptions{"city": {Fields: []string{"address.city"}}},
+	}
+	docs, err := sc.NewTableWithOptions[map[string]interface{}](db, "docs", opts)
+	if err != nil {
+		fmt.Println("FAIL: TestMapRows", err)
+		t.FailNow()
+	}
+	var d1, d2 map[string]interface{}
+	json.Unmarshal([]byte(`{"id": "x1", "address": {"city": "Auckland"}}`), &d1)
+	json.Unmarshal([]byte(`{"id": "x2", "address": {"city": "Auckland"}, "extra": [1, 2]}`), &d2)
+	if err = docs.Insert(d1, d2); err != nil {
+		fmt.Println("FAIL: TestMapRows insert", err)
+		t.FailNow()
+	}
+	if row, ok := docs.Get("id", "x2"); !ok || row["extra"] == nil || len(docs.GetAll("city", "Auckland")) != 2 {
+		fmt.Println("FAIL: TestMapRows lookup")
+		t.Fail()
+	}
+	if err = docs.Insert(map[string]interface{}{"id": "x3"}); err == nil {
+		fmt.Println("FAIL: TestMapRows missing field")
+		t.Fail()
+	}
+	docs.Upsert(map[string]interface{}{"id": "x1", "address": map[string]interface{}{"city": "Wellington"}})
+	if len(docs.GetAll("city", "Auckland")) != 1 || len(docs.GetAll("city", "Wellington")) != 1 {
+		fmt.Println("FAIL: TestMapRows update")
+		t.Fail()
+	}
+}
@@ -0,0 +1,192 @@
+package sc
+
+import (
+	"github.com/pkg/errors"
+)
+
+// How many rows AddIndexInBackground indexes per turn of the table lock. Readers and writers get the lock between turns
+var backfillChunk = 1000
+
+// An index which is still being filled in from the existing rows of a table
+type indexBuild struct {
+	name string
+	opts IndexOptions
+	idx Index
+	// first problem found while building, eg. a writer added a duplicate to a unique index being built
+	err error
+}
+
+// Add a row to the index being built. Any problem is remembered in b.err so the build fails once it notices, rather
+// than failing the write which caused it. Caller must hold the table write lock
+func (b *indexBuild) add(rec *record, fields fieldValues) {
+	if err := b.check(rec, fields); err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	b.idx.link(rec, fields)
+}
+
+// Make sure a row can go into the index being built, ie. it has every field and doesn't break uniqueness
+func (b *indexBuild) check(rec *record, fields fieldValues) error {
+	for _, f := range b.idx.Fields {
+		if !fields.has(f) {
+			return errors.Wrapf(ErrMissingIndexField, "Data obj %v doesn't have field %s needed by index %s", rec.row, f, b.name)
+		}
+	}
+	key, ok := b.idx.keyOf(fields)
+	if !ok {
+		return nil
+	}
+	if err := b.idx.checkKey(b.name, key); err != nil {
+		return err
+	}
+	for _, k := range b.idx.keysOf(fields) {
+		if !hashable(k) {
+			return errors.Wrapf(ErrUnhashableKey, "Key %v for index %s", k, b.name)
+		}
+	}
+	if b.idx.Unique {
+		if current, ok := b.idx.Idx[key]; ok && current != rec {
+			return ErrDuplicateKey{Index: b.name, Key: key}
+		}
+	}
+	return nil
+}
+
+// Add a new index to a table which may already hold data. The index is built from the existing rows before this
+// returns. Fails without changing anything if a row is missing one of the index fields or, for a unique index, if
+// two rows share a key.
+// The whole build holds the table lock, use AddIndexInBackground for big tables
+func (tbl *Table) AddIndex(name string, opts IndexOptions) error {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	b, err := tbl.startBuild(name, opts)
+	if err != nil {
+		return err
+	}
+	tbl.backfill(b, tbl.rowIDs())
+	return tbl.finishBuild(b)
+}
+
+// Same as AddIndex but the index is built in a separate goroutine, a chunk of rows at a time, so reads and writes
+// carry on while it runs. Writes made during the build are added to the new index as they happen.
+// The index only shows up in Indexes, LookupKey etc. once it is complete. The returned channel receives nil once
+// that has happened, or the reason the build failed
+func (tbl *Table) AddIndexInBackground(name string, opts IndexOptions) <-chan error {
+	done := make(chan error, 1)
+	tbl.mu.Lock()
+	b, err := tbl.startBuild(name, opts)
+	ids := tbl.rowIDs()
+	tbl.mu.Unlock()
+	if err != nil {
+		done <- err
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		for start := 0; start < len(ids); start += backfillChunk {
+			end := start + backfillChunk
+			if end > len(ids) {
+				end = len(ids)
+			}
+			tbl.mu.Lock()
+			tbl.backfill(b, ids[start:end])
+			stop := b.err != nil || tbl.building[name] != b
+			tbl.mu.Unlock()
+			if stop {
+				break
+			}
+		}
+		tbl.mu.Lock()
+		defer tbl.mu.Unlock()
+		done <- tbl.finishBuild(b)
+	}()
+	return done
+}
+
+// Remove an index from a table. The primary key can't be dropped. Dropping an index which is still being built by
+// AddIndexInBackground cancels the build
+func (tbl *Table) DropIndex(name string) error {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	if name == tbl.pk {
+		return errors.Errorf("Can't drop primary key index %s", name)
+	}
+	if _, ok := tbl.building[name]; ok {
+		delete(tbl.building, name)
+		return nil
+	}
+	if _, ok := tbl.Indexes[name]; !ok {
+		return errors.Wrapf(ErrNotFound, "Index %s does not exist in table %s", name, tbl.Name)
+	}
+	if tbl.wal != nil {
+		if err := tbl.wal.append([]walOp{{Kind: walDropIndex, Table: tbl.Name, Index: name}}); err != nil {
+			return err
+		}
+	}
+	// later writes won't touch the index, so snapshots need every row's place in it now
+	tbl.rememberAll()
+	delete(tbl.Indexes, name)
+	return nil
+}
+
+// Register a new index as being built so writers start maintaining it. Caller must hold the table write lock
+func (tbl *Table) startBuild(name string, opts IndexOptions) (*indexBuild, error) {
+	if _, ok := tbl.Indexes[name]; ok {
+		return nil, errors.Errorf("Index %s already exists in table %s", name, tbl.Name)
+	}
+	if _, ok := tbl.building[name]; ok {
+		return nil, errors.Errorf("Index %s is already being built in table %s", name, tbl.Name)
+	}
+	if err := checkIndexOptions(name, opts, false); err != nil {
+		return nil, err
+	}
+	if !opts.Unique && tbl.pk == "" {
+		return nil, errors.Errorf("Table %s needs a primary key for non unique index %s", tbl.Name, name)
+	}
+	b := &indexBuild{
+		name: name,
+		opts: opts,
+		idx: newIndex(name, opts),
+	}
+	tbl.building[name] = b
+	return b, nil
+}
+
+// Index the rows with the given IDs which are still in the table. Rows removed since are skipped and rows changed
+// since are indexed as they are now. Caller must hold the table write lock
+func (tbl *Table) backfill(b *indexBuild, ids []rowID) {
+	for _, id := range ids {
+		if b.err != nil {
+			return
+		}
+		rec, ok := tbl.rows[id]
+		if !ok {
+			continue
+		}
+		b.add(rec, fieldsOf(rec.row))
+	}
+}
+
+// Make a finished build visible, or throw it away if it failed or was dropped. Caller must hold the table write lock
+func (tbl *Table) finishBuild(b *indexBuild) error {
+	if tbl.building[b.name] != b {
+		return errors.Errorf("Index %s was dropped before it finished building", b.name)
+	}
+	delete(tbl.building, b.name)
+	if b.err != nil {
+		return errors.Wrapf(b.err, "Unable to build index %s", b.name)
+	}
+	if tbl.wal != nil {
+		if err := tbl.wal.append([]walOp{{Kind: walAddIndex, Table: tbl.Name, Index: b.name, IndexOptions: b.opts}}); err != nil {
+			return err
+		}
+	}
+	tbl.Indexes[b.name] = b.idx
+	return nil
+}
+
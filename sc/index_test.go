@@ -0,0 +1,193 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"errors"
+	"sync"
+)
+
+type indexTestObj struct {
+	Id string
+	Username string
+	Country string
+	City string
+}
+
+func hasIndex(table *sc.Table, name string) bool {
+	for _, idx := range table.ListIndexNames() {
+		if idx == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAddIndex(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTable("testTable", "Id")
+
+	obj1 := indexTestObj{Id: "Id1", Username: "User1", Country: "NZ", City: "Auckland"}
+	obj2 := indexTestObj{Id: "Id2", Username: "User2", Country: "NZ", City: "Wellington"}
+	obj3 := indexTestObj{Id: "Id3", Username: "User2", Country: "AU", City: "Sydney"}
+	table.InsertData(obj1, obj2, obj3)
+
+	// non unique index over existing data
+	if err := table.AddIndex("Country", sc.IndexOptions{}); err != nil || len(table.LookupAll("Country", "NZ")) != 2 {
+		fmt.Println("FAIL: AddIndex non unique", err)
+		t.Fail()
+	}
+
+	// unique index over data which already has duplicates should fail and leave nothing behind
+	err := table.AddIndex("Username", sc.IndexOptions{Unique: true})
+	var dupErr sc.ErrDuplicateKey
+	if !errors.As(err, &dupErr) || dupErr.Index != "Username" || hasIndex(table, "Username") {
+		fmt.Println("FAIL: AddIndex unique with duplicates", err)
+		t.Fail()
+	}
+
+	// compound unique index without duplicates
+	err = table.AddIndex("Country_City", sc.IndexOptions{Unique: true, Fields: []string{"Country", "City"}})
+	if err != nil || table.LookupKey(sc.Key("AU", "Sydney"), "Country_City") != obj3 {
+		fmt.Println("FAIL: AddIndex compound", err)
+		t.Fail()
+	}
+	// and it is enforced from then on
+	if err = table.InsertData(indexTestObj{Id: "Id4", Country: "AU", City: "Sydney"}); err == nil {
+		fmt.Println("FAIL: AddIndex new unique index not enforced")
+		t.Fail()
+	}
+
+	// field that doesn't exist on the rows
+	if err = table.AddIndex("Email", sc.IndexOptions{}); err == nil || hasIndex(table, "Email") {
+		fmt.Println("FAIL: AddIndex missing field")
+		t.Fail()
+	}
+
+	// name that's already taken
+	if err = table.AddIndex("Country", sc.IndexOptions{}); err == nil {
+		fmt.Println("FAIL: AddIndex existing name")
+		t.Fail()
+	}
+
+	// new rows go into the new index
+	table.InsertData(indexTestObj{Id: "Id5", Country: "NZ", City: "Hamilton"})
+	if len(table.LookupAll("Country", "NZ")) != 3 {
+		fmt.Println("FAIL: AddIndex new rows not indexed")
+		t.Fail()
+	}
+}
+
+func TestDropIndex(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTable("testTable", "Id", "Username")
+	table.InsertData(indexTestObj{Id: "Id1", Username: "User1"})
+
+	if err := table.DropIndex("Id"); err == nil || !hasIndex(table, "Id") {
+		fmt.Println("FAIL: DropIndex primary key")
+		t.Fail()
+	}
+	if err := table.DropIndex("Nope"); err == nil {
+		fmt.Println("FAIL: DropIndex missing index")
+		t.Fail()
+	}
+	if err := table.DropIndex("Username"); err != nil || hasIndex(table, "Username") {
+		fmt.Println("FAIL: DropIndex", err)
+		t.Fail()
+	}
+
+	// rows only need the remaining index fields now, and the dropped key is free again
+	type idOnly struct {
+		Id string
+	}
+	if err := table.InsertData(idOnly{Id: "Id2"}); err != nil || sc.GetTableSize(table) != 2 {
+		fmt.Println("FAIL: Insert after DropIndex", err)
+		t.Fail()
+	}
+	if err := table.AddIndex("Username", sc.IndexOptions{Unique: true}); err == nil {
+		fmt.Println("FAIL: AddIndex after dropping with rows missing the field")
+		t.Fail()
+	}
+}
+
+// Run with -race. Builds an index over a table, a few rows at a time, other goroutines keep reading and writing
+func TestAddIndexInBackground(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTable("testTable", "Id")
+	defer sc.SetBackfillChunk(20)()
+
+	rows := 200
+	for i := 0; i < rows; i++ {
+		table.SetData(indexTestObj{Id: fmt.Sprintf("Id%d", i), Username: fmt.Sprintf("User%d", i), Country: "NZ"})
+	}
+
+	done := table.AddIndexInBackground("Username", sc.IndexOptions{Unique: true})
+	var wg sync.WaitGroup
+	// writers moving rows to new usernames, deleting some and adding others
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			table.SetData(indexTestObj{Id: fmt.Sprintf("Id%d", i), Username: fmt.Sprintf("Renamed%d", i)})
+			table.Delete("Id", fmt.Sprintf("Id%d", rows - 1 - i))
+			table.InsertData(indexTestObj{Id: fmt.Sprintf("New%d", i), Username: fmt.Sprintf("NewUser%d", i)})
+		}
+	}()
+	// readers using the primary key the whole time. The new index must be complete whenever it is visible
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if table.LookupKey(fmt.Sprintf("Id%d", 50 + i), "Id") == nil {
+				t.Error("FAIL: AddIndexInBackground read during build")
+			}
+			if hasIndex(table, "Username") && table.LookupKey(fmt.Sprintf("User%d", 50 + i), "Username") == nil {
+				t.Error("FAIL: AddIndexInBackground index visible before complete")
+			}
+		}
+	}()
+	if err := <-done; err != nil {
+		fmt.Println("FAIL: AddIndexInBackground", err)
+		t.Fail()
+	}
+	wg.Wait()
+
+	// every row should be under its current username and nothing else should be in there
+	if len(table.Indexes["Username"].Idx) != sc.GetTableSize(table) {
+		fmt.Println("FAIL: AddIndexInBackground index size", len(table.Indexes["Username"].Idx), sc.GetTableSize(table))
+		t.Fail()
+	}
+	for i := 0; i < rows; i++ {
+		for _, id := range []string{fmt.Sprintf("Id%d", i), fmt.Sprintf("New%d", i)} {
+			row := table.LookupKey(id, "Id")
+			if row != nil && table.LookupKey(row.(indexTestObj).Username, "Username") != row {
+				fmt.Println("FAIL: AddIndexInBackground row missing", row)
+				t.Fail()
+			}
+		}
+	}
+	if table.LookupKey("User0", "Username") != nil || table.LookupKey("Renamed0", "Username") == nil {
+		fmt.Println("FAIL: AddIndexInBackground stale key after rename")
+		t.Fail()
+	}
+
+	// a writer creating a duplicate while a unique index is being built makes the build fail
+	done = table.AddIndexInBackground("Country", sc.IndexOptions{Unique: true})
+	if err := <-done; err == nil || hasIndex(table, "Country") {
+		fmt.Println("FAIL: AddIndexInBackground duplicates")
+		t.Fail()
+	}
+
+	// dropping straight away either cancels the build or drops the finished index, either way it's gone
+	done = table.AddIndexInBackground("City", sc.IndexOptions{})
+	if err := table.DropIndex("City"); err != nil {
+		fmt.Println("FAIL: DropIndex while building", err)
+		t.Fail()
+	}
+	<-done
+	if hasIndex(table, "City") {
+		fmt.Println("FAIL: DropIndex while building left index behind")
+		t.Fail()
+	}
+}
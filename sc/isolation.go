@@ -0,0 +1,164 @@
+package sc
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Whether values of a type can refer to memory outside themselves, ie. whether a copy of one can still be changed
+// through the original, by reflect.Type
+var copyCache sync.Map
+
+// Deep copy of a row for a table with TableOptions.CopyRows, so the table and its callers never share anything they
+// could change under each other. Pointers, slices, maps and interfaces are followed and copied, data which is shared
+// or cyclic in the row stays that way in the copy. Unexported fields are copied as they are, so whatever they point
+// to is still shared, and channels and funcs aren't copied either.
+// Rows without any references, eg. a struct of strings and ints stored by value, are returned as is since nobody can
+// change the copy in an interface{}
+func copyRow(row interface{}) interface{} {
+	if row == nil || !needsCopy(reflect.TypeOf(row)) {
+		return row
+	}
+	c := copier{seen: make(map[copySeen]reflect.Value)}
+	return c.copy(reflect.ValueOf(row)).Interface()
+}
+
+// Whether values of typ have any pointers, slices, maps etc. in them
+func needsCopy(typ reflect.Type) bool {
+	if needs, ok := copyCache.Load(typ); ok {
+		return needs.(bool)
+	}
+	needs := false
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		needs = true
+	case reflect.Array:
+		needs = needsCopy(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if needsCopy(typ.Field(i).Type) {
+				needs = true
+				break
+			}
+		}
+	}
+	copyCache.Store(typ, needs)
+	return needs
+}
+
+type copySeen struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// Copies one row, remembering the pointers and maps already copied so they are only copied once
+type copier struct {
+	seen map[copySeen]reflect.Value
+}
+
+func (c copier) copy(v reflect.Value) reflect.Value {
+	if !needsCopy(v.Type()) {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		key := copySeen{v.Pointer(), v.Type()}
+		if done, ok := c.seen[key]; ok {
+			return done
+		}
+		out := reflect.New(v.Type().Elem())
+		c.seen[key] = out
+		out.Elem().Set(c.copy(v.Elem()))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(c.copy(v.Elem()))
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				out.Field(i).Set(c.copy(v.Field(i)))
+			}
+		}
+		return out
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := copySeen{v.Pointer(), v.Type()}
+		if done, ok := c.seen[key]; ok {
+			return done
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		c.seen[key] = out
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(c.copy(iter.Key()), c.copy(iter.Value()))
+		}
+		return out
+	}
+	return v
+}
+
+// A row on its way out of the table to a caller: a copy of it for a table with CopyRows, after checking it for drift
+// in debug mode. Caller must hold the table lock
+func (tbl *Table) rowOut(rec *record) interface{} {
+	tbl.checkDrift(rec)
+	if tbl.copyRows {
+		return copyRow(rec.row)
+	}
+	return rec.row
+}
+
+// Every key a row is stored under by index name, remembered in debug mode so checkDrift can tell if they change
+func (tbl *Table) indexKeys(fields fieldValues) map[string][]interface{} {
+	keys := make(map[string][]interface{}, len(tbl.Indexes))
+	for name, idx := range tbl.Indexes {
+		keys[name] = idx.keysOf(fields)
+	}
+	return keys
+}
+
+// In debug mode, log a warning for each index where a row's keys are no longer the ones it was stored under. This
+// happens when a caller changes a row they passed in by pointer, or one they got back from a lookup, without setting
+// it again. The row is then found under its old keys but shows the new values, and can't be found under the new
+// ones. Use CopyRows to stop it happening. Caller must hold the table lock
+func (tbl *Table) checkDrift(rec *record) {
+	if rec.keys == nil {
+		return
+	}
+	fields := fieldsOf(rec.row)
+	for name, idx := range tbl.Indexes {
+		was, ok := rec.keys[name]
+		if !ok {
+			// index was added after the row was written
+			continue
+		}
+		if now := idx.keysOf(fields); !sameKeys(was, now) {
+			tbl.db.logger.Warn("drift", "table", tbl.Name, "index", name, "pk", rec.pk, "was", was, "now", now)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+type isoTestObj struct {
+	Id int
+	Username string
+	Tags []string
+	Manager *isoTestObj
+	Self *isoTestObj
+}
+
+func TestCopyRows(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Username": {Unique: true}, "Tags": {Multi: true}},
+		CopyRows: true,
+	}
+	table, _ := db.AddTableWithOptions("users", opts)
+	boss := &isoTestObj{Id: 2, Username: "boss"}
+	obj := &isoTestObj{Id: 1, Username: "alice", Tags: []string{"go"}, Manager: boss}
+	obj.Self = obj
+	table.InsertData(obj)
+
+	// changing what was inserted doesn't reach the table
+	obj.Username = "changed"
+	obj.Tags[0] = "rust"
+	boss.Username = "changed"
+	row, ok := table.LookupKey("alice", "Username").(*isoTestObj)
+	if !ok || row == obj || row.Username != "alice" || row.Tags[0] != "go" || row.Manager.Username != "boss" {
+		fmt.Println("FAIL: TestCopyRows inserted row shared", row)
+		t.Fail()
+	}
+	if row.Self != row {
+		fmt.Println("FAIL: TestCopyRows cycle not kept in the copy")
+		t.Fail()
+	}
+	if table.LookupKey("changed", "Username") != nil || len(table.LookupAll("Tags", "rust")) != 0 {
+		fmt.Println("FAIL: TestCopyRows found under changed keys")
+		t.Fail()
+	}
+
+	// nor does changing what comes back
+	row.Username = "mallory"
+	row.Tags = append(row.Tags[:0], "evil")
+	again := table.LookupAll("Tags", "go")
+	if len(again) != 1 || again[0].(*isoTestObj).Username != "alice" || again[0] == row {
+		fmt.Println("FAIL: TestCopyRows returned row shared", again)
+		t.Fail()
+	}
+	table.DeleteWhere(func(r interface{}) bool {
+		r.(*isoTestObj).Username = "mallory"
+		return false
+	})
+	if table.LookupKey("alice", "Username") == nil {
+		fmt.Println("FAIL: TestCopyRows DeleteWhere changed a row")
+		t.Fail()
+	}
+
+	// map rows are copied too
+	docs, _ := db.AddTableWithOptions("docs", sc.TableOptions{PrimaryKey: "Id", CopyRows: true})
+	doc := map[string]interface{}{"Id": 1, "Address": map[string]interface{}{"City": "Wellington"}}
+	docs.InsertData(doc)
+	doc["Address"].(map[string]interface{})["City"] = "Auckland"
+	if city := docs.LookupKey(1, "Id").(map[string]interface{})["Address"].(map[string]interface{})["City"]; city != "Wellington" {
+		fmt.Println("FAIL: TestCopyRows map row shared", city)
+		t.Fail()
+	}
+}
+
+// In debug mode a row changed behind the table's back is reported the next time the table touches it
+func TestDrift(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	db := sc.InitDb("testdb", sc.WithLogger(logger))
+	table, _ := db.AddTable("users", "Id", "Username")
+	obj := &isoTestObj{Id: 1, Username: "alice"}
+	table.InsertData(obj, &isoTestObj{Id: 2, Username: "bob"})
+
+	drifts := func() []map[string]interface{} {
+		var found []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var event map[string]interface{}
+			json.Unmarshal([]byte(line), &event)
+			if event["msg"] == "drift" {
+				found = append(found, event)
+			}
+		}
+		logs.Reset()
+		return found
+	}
+
+	table.LookupKey(1, "Id")
+	table.LookupKey(2, "Id")
+	if found := drifts(); len(found) != 0 {
+		fmt.Println("FAIL: TestDrift false alarm", found)
+		t.Fail()
+	}
+
+	obj.Username = "changed"
+	table.LookupKey(1, "Id")
+	found := drifts()
+	if len(found) != 1 || found[0]["level"] != "WARN" || found[0]["index"] != "Username" || found[0]["pk"] != 1.0 ||
+		fmt.Sprint(found[0]["was"], found[0]["now"]) != "[alice] [changed]" {
+		fmt.Println("FAIL: TestDrift not reported", found)
+		t.Fail()
+	}
+
+	// setting it again puts it right
+	table.SetData(obj)
+	table.LookupKey("changed", "Username")
+	if found := drifts(); len(found) != 1 || table.LookupKey("changed", "Username") != obj {
+		fmt.Println("FAIL: TestDrift after setting again", found)
+		t.Fail()
+	}
+	table.LookupKey(1, "Id")
+	if found := drifts(); len(found) != 0 {
+		fmt.Println("FAIL: TestDrift still reported after setting again", found)
+		t.Fail()
+	}
+}
@@ -0,0 +1,38 @@
+package sc
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// Changes a default when creating a Database with InitDb
+type Option func(db *Database)
+
+// Send debug level events about writes to logger: insert, update, conflict and evict, each with the table name and
+// the row's primary key. Nothing is logged unless one of these options is given
+func WithLogger(logger *slog.Logger) Option {
+	return func(db *Database) {
+		if logger == nil {
+			logger = silentLogger
+		}
+		db.logger = logger
+	}
+}
+
+// Log every debug event to stderr as text, handy while working on something. Use WithLogger in production.
+// A logger which takes debug events also turns on drift detection, which warns when a row's keys have changed since
+// it was set, at the cost of keeping a copy of every row's keys
+func WithDebug() Option {
+	return WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+}
+
+// Default logger which drops everything without formatting it first
+var silentLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h discardHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,227 @@
+package sc
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How to walk an ordered index in Range and Prefix
+type RangeOptions struct {
+	// Highest key first instead of lowest
+	Desc bool
+	// Skip this many matching rows before returning any
+	Offset int
+	// Return at most this many rows. 0 means no limit
+	Limit int
+}
+
+// Rows with a key between lo and hi inclusive in an ordered index. Either bound can be nil to leave that end open,
+// eg. table.Range("Created", t1, t2, sc.RangeOptions{}) for orders created between t1 and t2.
+// Bounds must be the same sort of value as the field, ie. any number for a numeric field, a string or a time.Time.
+// Rows with the same key come back in the order they were written. O(log n + offset + m) for m rows returned
+func (tbl *Table) Range(index string, lo, hi interface{}, opts RangeOptions) ([]interface{}, error) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.rangeRows(index, lo, hi, opts)
+}
+
+// Does the work of Range. Caller must hold the table lock
+func (tbl *Table) rangeRows(index string, lo, hi interface{}, opts RangeOptions) ([]interface{}, error) {
+	sl, err := tbl.skipListOf(index, OrderedIndex)
+	if err != nil {
+		return nil, err
+	}
+	idx := tbl.Indexes[index]
+	var loKey, hiKey interface{}
+	if lo != nil {
+		if loKey, err = sl.bound(index, idx.normalize(lo)); err != nil {
+			return nil, err
+		}
+	}
+	if hi != nil {
+		if hiKey, err = sl.bound(index, idx.normalize(hi)); err != nil {
+			return nil, err
+		}
+	}
+
+	var start *skipNode
+	var within func(key interface{}) bool
+	if opts.Desc {
+		start = sl.tail
+		if hi != nil {
+			start = sl.lastUpTo(hiKey, true)
+		}
+		within = func(key interface{}) bool { return lo == nil || sl.cmp(key, loKey) >= 0 }
+	} else {
+		start = sl.head.levels[0].next
+		if lo != nil {
+			start = sl.firstFrom(loKey)
+		}
+		within = func(key interface{}) bool { return hi == nil || sl.cmp(key, hiKey) <= 0 }
+	}
+	return tbl.walk(start, within, opts), nil
+}
+
+// Rows whose key starts with prefix in an ordered index on a string field. O(log n + offset + m) for m rows returned
+func (tbl *Table) Prefix(index string, prefix string, opts RangeOptions) ([]interface{}, error) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.prefixRows(index, prefix, opts)
+}
+
+// Does the work of Prefix. Caller must hold the table lock
+func (tbl *Table) prefixRows(index string, prefix string, opts RangeOptions) ([]interface{}, error) {
+	sl, err := tbl.skipListOf(index, OrderedIndex)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = sl.bound(index, prefix); err != nil {
+		return nil, err
+	}
+	if tbl.Indexes[index].Lower {
+		prefix = strings.ToLower(prefix)
+	}
+
+	var start *skipNode
+	if opts.Desc {
+		start = sl.tail
+		if end, ok := prefixEnd(prefix); ok {
+			start = sl.lastUpTo(end, false)
+		}
+	} else {
+		start = sl.firstFrom(prefix)
+	}
+	within := func(key interface{}) bool { return strings.HasPrefix(key.(string), prefix) }
+	return tbl.walk(start, within, opts), nil
+}
+
+// Collect rows from start onwards, forwards or backwards, until a key is no longer within range or the limit is hit.
+// Expired rows are skipped and don't count towards offset or limit. Caller must hold the table lock
+func (tbl *Table) walk(start *skipNode, within func(key interface{}) bool, opts RangeOptions) []interface{} {
+	var rows []interface{}
+	now := time.Now()
+	skip := opts.Offset
+	for n := start; n != nil && within(n.key); {
+		if !tbl.expired(n.rec, now) {
+			if skip > 0 {
+				skip--
+			} else {
+				tbl.accessed(n.rec)
+				rows = append(rows, tbl.rowOut(n.rec))
+				if opts.Limit > 0 && len(rows) == opts.Limit {
+					break
+				}
+			}
+		}
+		if opts.Desc {
+			n = n.prev
+		} else {
+			n = n.levels[0].next
+		}
+	}
+	return rows
+}
+
+// Turn a Range bound into a key which can be compared with the ones in the list
+func (sl *skipList) bound(index string, v interface{}) (interface{}, error) {
+	key, ok := orderedKey(v)
+	if !ok {
+		return nil, errors.Errorf("Can't use %v as a bound in ordered index %s", v, index)
+	}
+	if first := sl.head.levels[0].next; first != nil && orderClass(first.key) != orderClass(key) {
+		return nil, errors.Errorf("Bound %v is a different type to the keys in ordered index %s", v, index)
+	}
+	return key, nil
+}
+
+// The smallest string greater than every string starting with prefix. ok is false if there isn't one,
+// ie. the prefix is empty or all 0xff bytes
+func prefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i + 1]), true
+		}
+	}
+	return "", false
+}
+
+// A field value as something compareOrdered understands: int64, uint64, float64, string or time.Time.
+// Named types like type Status string are converted to their underlying type. NaN can't be ordered so is rejected
+func orderedKey(v interface{}) (interface{}, bool) {
+	if t, ok := v.(time.Time); ok {
+		return t, true
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return val.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f, ok := toScore(v)
+		return f, ok
+	case reflect.String:
+		return val.String(), true
+	}
+	return nil, false
+}
+
+// Which keys can be compared with each other. All numbers can be
+func orderClass(key interface{}) int {
+	switch key.(type) {
+	case string:
+		return 1
+	case time.Time:
+		return 2
+	}
+	return 0
+}
+
+// Compare two keys from orderedKey of the same class
+func compareOrdered(a, b interface{}) int {
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case time.Time:
+		return x.Compare(b.(time.Time))
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareInts(x, y)
+		case uint64:
+			if x < 0 {
+				return -1
+			}
+			return compareInts(uint64(x), y)
+		}
+	case uint64:
+		switch y := b.(type) {
+		case uint64:
+			return compareInts(x, y)
+		case int64:
+			if y < 0 {
+				return 1
+			}
+			return compareInts(x, uint64(y))
+		}
+	}
+	// at least one of them is a float
+	x, _ := toScore(a)
+	y, _ := toScore(b)
+	return compareScores(x, y)
+}
+
+func compareInts[T int64 | uint64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
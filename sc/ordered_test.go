@@ -0,0 +1,172 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"time"
+)
+
+type order struct {
+	Id int
+	Username string
+	Created time.Time
+	Total float64
+}
+
+func newOrderTable(t *testing.T) (*sc.TypedTable[order], time.Time) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{
+			"Username": {Kind: sc.OrderedIndex},
+			"Created": {Kind: sc.OrderedIndex},
+			"Total": {Kind: sc.OrderedIndex},
+		},
+	}
+	orders, err := sc.NewTableWithOptions[order](db, "orders", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	orders.Insert(
+		order{1, "abby", base.Add(3 * time.Hour), 10},
+		order{2, "abe", base.Add(1 * time.Hour), 25.5},
+		order{3, "bob", base.Add(5 * time.Hour), 7},
+		order{4, "ab", base.Add(2 * time.Hour), 100},
+		order{5, "carl", base.Add(4 * time.Hour), 25.5},
+	)
+	return orders, base
+}
+
+func ids(rows []order) string {
+	result := make([]int, len(rows))
+	for i, row := range rows {
+		result[i] = row.Id
+	}
+	return fmt.Sprint(result)
+}
+
+func TestRange(t *testing.T) {
+	orders, base := newOrderTable(t)
+
+	rows, err := orders.Range("Created", base.Add(2 * time.Hour), base.Add(4 * time.Hour), sc.RangeOptions{})
+	if err != nil || ids(rows) != "[4 1 5]" {
+		fmt.Println("FAIL: TestRange time", ids(rows), err)
+		t.Fail()
+	}
+	rows, _ = orders.Range("Created", base.Add(2 * time.Hour), nil, sc.RangeOptions{Desc: true})
+	if ids(rows) != "[3 5 1 4]" {
+		fmt.Println("FAIL: TestRange desc open ended", ids(rows))
+		t.Fail()
+	}
+	rows, _ = orders.Range("Created", nil, nil, sc.RangeOptions{Offset: 1, Limit: 2})
+	if ids(rows) != "[4 1]" {
+		fmt.Println("FAIL: TestRange offset and limit", ids(rows))
+		t.Fail()
+	}
+	rows, _ = orders.Range("Created", nil, nil, sc.RangeOptions{Desc: true, Offset: 4, Limit: 2})
+	if ids(rows) != "[2]" {
+		fmt.Println("FAIL: TestRange desc offset", ids(rows))
+		t.Fail()
+	}
+	// an int bound works on a float field and equal keys keep their insertion order
+	rows, _ = orders.Range("Total", 10, 26, sc.RangeOptions{})
+	if ids(rows) != "[1 2 5]" {
+		fmt.Println("FAIL: TestRange numeric", ids(rows))
+		t.Fail()
+	}
+	rows, _ = orders.Range("Username", "abe", "bob", sc.RangeOptions{})
+	if ids(rows) != "[2 3]" {
+		fmt.Println("FAIL: TestRange string", ids(rows))
+		t.Fail()
+	}
+
+	// updates and deletes keep the index in step
+	orders.Upsert(order{3, "bob", base, 7})
+	orders.Delete("Id", 1)
+	rows, _ = orders.Range("Created", nil, nil, sc.RangeOptions{})
+	if ids(rows) != "[3 2 4 5]" {
+		fmt.Println("FAIL: TestRange after writes", ids(rows))
+		t.Fail()
+	}
+
+	if _, err = orders.Range("Created", "yesterday", nil, sc.RangeOptions{}); err == nil {
+		fmt.Println("FAIL: TestRange bound of the wrong type")
+		t.Fail()
+	}
+	if _, err = orders.Range("Id", 1, 2, sc.RangeOptions{}); err == nil {
+		fmt.Println("FAIL: TestRange hash index")
+		t.Fail()
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	orders, _ := newOrderTable(t)
+
+	rows, err := orders.Prefix("Username", "ab", sc.RangeOptions{})
+	if err != nil || ids(rows) != "[4 1 2]" {
+		fmt.Println("FAIL: TestPrefix", ids(rows), err)
+		t.Fail()
+	}
+	rows, _ = orders.Prefix("Username", "ab", sc.RangeOptions{Desc: true, Limit: 2})
+	if ids(rows) != "[2 1]" {
+		fmt.Println("FAIL: TestPrefix desc", ids(rows))
+		t.Fail()
+	}
+	rows, _ = orders.Prefix("Username", "", sc.RangeOptions{Desc: true})
+	if ids(rows) != "[5 3 2 1 4]" {
+		fmt.Println("FAIL: TestPrefix empty", ids(rows))
+		t.Fail()
+	}
+	if rows, _ = orders.Prefix("Username", "z", sc.RangeOptions{}); len(rows) != 0 {
+		fmt.Println("FAIL: TestPrefix no matches", ids(rows))
+		t.Fail()
+	}
+	if _, err = orders.Prefix("Created", "2020", sc.RangeOptions{}); err == nil {
+		fmt.Println("FAIL: TestPrefix on a time index")
+		t.Fail()
+	}
+}
+
+// Map rows can have any type under a field, but an ordered index only takes keys of the sort already in it
+func TestOrderedMixedKeys(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTableWithOptions("docs", sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Created": {Kind: sc.OrderedIndex}},
+	})
+	if err := table.SetData(map[string]interface{}{"Id": "a", "Created": "2024"}); err != nil {
+		fmt.Println("FAIL: TestOrderedMixedKeys first row", err)
+		t.Fail()
+	}
+	if err := table.SetData(map[string]interface{}{"Id": "b", "Created": 5.0}); err == nil {
+		fmt.Println("FAIL: TestOrderedMixedKeys number after string")
+		t.Fail()
+	}
+	err := table.InsertData(map[string]interface{}{"Id": "c", "Created": "2025"}, map[string]interface{}{"Id": "d", "Created": 6})
+	if err == nil {
+		fmt.Println("FAIL: TestOrderedMixedKeys mixed batch")
+		t.Fail()
+	}
+	if err := table.UpdateData(map[string]interface{}{"Id": "a", "Created": time.Now()}); err == nil {
+		fmt.Println("FAIL: TestOrderedMixedKeys update to time")
+		t.Fail()
+	}
+	// nothing half added
+	if sc.StoredRows(table) != 1 || table.LookupKey("b", "Id") != nil || table.LookupKey("c", "Id") != nil {
+		fmt.Println("FAIL: TestOrderedMixedKeys rows", sc.StoredRows(table))
+		t.Fail()
+	}
+	if rows, err := table.Range("Created", "2000", nil, sc.RangeOptions{}); err != nil || len(rows) != 1 {
+		fmt.Println("FAIL: TestOrderedMixedKeys range", rows, err)
+		t.Fail()
+	}
+
+	// an empty table takes whatever comes first
+	table.Delete("Id", "a")
+	if err := table.SetData(map[string]interface{}{"Id": "e", "Created": 5}, map[string]interface{}{"Id": "f", "Created": 2.5}); err != nil {
+		fmt.Println("FAIL: TestOrderedMixedKeys numbers", err)
+		t.Fail()
+	}
+}
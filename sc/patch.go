@@ -0,0 +1,141 @@
+package sc
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Change some fields of the row stored under key in a unique index, leaving the rest as they are, eg.
+// table.Patch("Id", 1, map[string]interface{}{"Username": "bob", "Address.City": "Auckland"})
+// Field names can be dotted paths to nested fields the same as in IndexOptions.Fields, a nil pointer on the way is
+// filled in with a new struct. For map[string]interface{} rows the names are keys, going through nested maps.
+// Values must be assignable to the field, or a number or string convertible to its type.
+// The row is only moved in the indexes whose keys changed. Fails the same way as UpdateData if a new key belongs to
+// another row, or if any value can't be set, without changing anything. Returns the row before and after
+func (tbl *Table) Patch(index string, key interface{}, changes map[string]interface{}) (before, after interface{}, err error) {
+	return tbl.Modify(index, key, func(row interface{}) (interface{}, error) {
+		return patchRow(row, changes)
+	})
+}
+
+// Change the row stored under key in a unique index with a function which is given a copy of the row and returns
+// the new one. The change is applied under the table lock so nobody else can write the row in between, which also
+// means change must not use this table. If change returns an error nothing is changed and that error is returned.
+// Otherwise the new row goes in the same way as with Patch. Returns the row before and after
+func (tbl *Table) Modify(index string, key interface{}, change func(row interface{}) (interface{}, error)) (before, after interface{}, err error) {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	before, after, err = tbl.modify(index, key, change)
+	if err = tbl.commit(err); err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// Does the work of Modify. Caller must hold the table write lock
+func (tbl *Table) modify(index string, key interface{}, change func(row interface{}) (interface{}, error)) (before, after interface{}, err error) {
+	if _, err = tbl.uniqueIndex(index); err != nil {
+		return nil, nil, err
+	}
+	rec, ok := tbl.liveRecord(index, key)
+	if !ok {
+		return nil, nil, errors.Wrapf(ErrNotFound, "Key %v in index %s", key, index)
+	}
+	before = tbl.rowOut(rec)
+	if after, err = change(copyRow(rec.row)); err != nil {
+		return nil, nil, err
+	}
+	if err = tbl.checkRow(after); err != nil {
+		return nil, nil, err
+	}
+	if err = tbl.checkUpdates([]*record{rec}, map[*record]bool{rec: true}, []interface{}{after}); err != nil {
+		return nil, nil, err
+	}
+	tbl.update(rec, after)
+	return before, tbl.rowOut(rec), nil
+}
+
+// row with the given fields changed. row must be a private copy since pointer and map rows are changed in place
+func patchRow(row interface{}, changes map[string]interface{}) (interface{}, error) {
+	if doc, ok := row.(map[string]interface{}); ok {
+		for name, value := range changes {
+			setDocValue(doc, name, value)
+		}
+		return doc, nil
+	}
+	val := reflect.ValueOf(row)
+	target := val
+	if val.Kind() == reflect.Ptr && !val.IsNil() {
+		target = val.Elem()
+	} else if val.Kind() == reflect.Struct {
+		// a struct in an interface{} can't be changed, so work on a copy which can
+		target = reflect.New(val.Type()).Elem()
+		target.Set(val)
+		val = target
+	}
+	if target.Kind() != reflect.Struct {
+		return nil, errors.Wrapf(ErrNotStruct, "Can't patch %v of type %T", row, row)
+	}
+	info := infoFor(target.Type())
+	for name, value := range changes {
+		path := info.path(name)
+		if !path.ok {
+			return nil, errors.Errorf("Row of type %T has no exported field %s", row, name)
+		}
+		field := target
+		for _, i := range path.index {
+			for field.Kind() == reflect.Ptr {
+				if field.IsNil() {
+					if !field.CanSet() {
+						return nil, errors.Errorf("Can't fill in the nil pointer on the way to field %s", name)
+					}
+					field.Set(reflect.New(field.Type().Elem()))
+				}
+				field = field.Elem()
+			}
+			field = field.Field(i)
+		}
+		if err := setValue(field, value); err != nil {
+			return nil, errors.Wrapf(err, "Field %s", name)
+		}
+	}
+	return val.Interface(), nil
+}
+
+// Set a field to value, converting numbers and strings to the field's type. nil sets the zero value
+func setValue(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case isNumber(v.Type()) && isNumber(field.Type()), v.Kind() == reflect.String && field.Kind() == reflect.String:
+		field.Set(v.Convert(field.Type()))
+	default:
+		return errors.Errorf("Can't set a %s to %v of type %T", field.Type(), value, value)
+	}
+	return nil
+}
+
+// Set a key in a map row. A dotted name goes through nested maps, which are created if they aren't there yet
+func setDocValue(doc map[string]interface{}, name string, value interface{}) {
+	if _, ok := doc[name]; ok || !strings.Contains(name, ".") {
+		doc[name] = value
+		return
+	}
+	parts := strings.Split(name, ".")
+	for _, part := range parts[:len(parts) - 1] {
+		nested, ok := doc[part].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+			doc[part] = nested
+		}
+		doc = nested
+	}
+	doc[parts[len(parts) - 1]] = value
+}
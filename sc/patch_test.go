@@ -0,0 +1,172 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"errors"
+)
+
+type patchAddress struct {
+	City string
+}
+
+type patchTestObj struct {
+	Id int
+	Username string
+	Score int
+	Visits int64
+	Bio string
+	Address *patchAddress
+}
+
+func patchTestTable() *sc.Table {
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{
+			"Username": {Unique: true},
+			"Score": {Kind: sc.SortedIndex},
+			"City": {Fields: []string{"Address.City"}},
+		},
+	}
+	table, _ := sc.InitDb("testdb").AddTableWithOptions("users", opts)
+	table.InsertData(
+		patchTestObj{Id: 1, Username: "alice", Score: 10},
+		patchTestObj{Id: 2, Username: "bob", Score: 10},
+		patchTestObj{Id: 3, Username: "carol", Score: 20, Address: &patchAddress{"Wellington"}},
+	)
+	return table
+}
+
+func TestPatch(t *testing.T) {
+	table := patchTestTable()
+
+	// alice ranks before bob on the same score, changing a field which isn't indexed doesn't move her
+	before, after, err := table.Patch("Id", 1, map[string]interface{}{"Bio": "hi", "Visits": 3})
+	if err != nil || before.(patchTestObj).Bio != "" || after.(patchTestObj).Bio != "hi" || after.(patchTestObj).Visits != 3 {
+		fmt.Println("FAIL: TestPatch", before, after, err)
+		t.Fail()
+	}
+	if rank, _ := table.Rank("Score", 1); rank != 0 {
+		fmt.Println("FAIL: TestPatch unindexed field moved the row", rank)
+		t.Fail()
+	}
+
+	// changing indexed fields moves the row, through a nil pointer and by another unique index
+	_, after, err = table.Patch("Username", "alice", map[string]interface{}{"Username": "alicia", "Address.City": "Auckland"})
+	if err != nil || after.(patchTestObj).Address.City != "Auckland" || after.(patchTestObj).Username != "alicia" {
+		fmt.Println("FAIL: TestPatch indexed fields", after, err)
+		t.Fail()
+	}
+	if table.LookupKey("alice", "Username") != nil || table.LookupKey("alicia", "Username").(patchTestObj).Bio != "hi" ||
+		len(table.LookupAll("City", "Auckland")) != 1 {
+		fmt.Println("FAIL: TestPatch not re-keyed")
+		t.Fail()
+	}
+
+	// carol's address is shared with the stored row so must not be changed in place
+	table.Patch("Id", 3, map[string]interface{}{"Score": 5})
+	if rank, _ := table.Rank("Score", 3); rank != 0 || len(table.LookupAll("City", "Wellington")) != 1 {
+		fmt.Println("FAIL: TestPatch score", rank)
+		t.Fail()
+	}
+}
+
+func TestPatchFails(t *testing.T) {
+	table := patchTestTable()
+	bad := []map[string]interface{}{
+		{"Bio": "hi", "Nope": 1},
+		{"Bio": "hi", "Score": "ten"},
+		{"Bio": "hi", "Address": 7},
+	}
+	for _, changes := range bad {
+		if _, _, err := table.Patch("Id", 1, changes); err == nil {
+			fmt.Println("FAIL: TestPatchFails", changes)
+			t.Fail()
+		}
+	}
+	_, _, err := table.Patch("Id", 1, map[string]interface{}{"Bio": "hi", "Username": "bob"})
+	if !errors.As(err, new(sc.ErrDuplicateKey)) {
+		fmt.Println("FAIL: TestPatchFails duplicate key", err)
+		t.Fail()
+	}
+	if row := table.LookupKey(1, "Id").(patchTestObj); row.Bio != "" || row.Username != "alice" {
+		fmt.Println("FAIL: TestPatchFails row changed", row)
+		t.Fail()
+	}
+
+	if _, _, err = table.Patch("Id", 9, map[string]interface{}{"Bio": "hi"}); !errors.Is(err, sc.ErrNotFound) {
+		fmt.Println("FAIL: TestPatchFails missing row", err)
+		t.Fail()
+	}
+	if _, _, err = table.Patch("Score", 10, map[string]interface{}{"Bio": "hi"}); err == nil {
+		fmt.Println("FAIL: TestPatchFails non unique index")
+		t.Fail()
+	}
+}
+
+func TestPatchPointersAndMaps(t *testing.T) {
+	db := sc.InitDb("testdb")
+	users, _ := db.AddTable("users", "Id", "Username")
+	obj := &patchTestObj{Id: 1, Username: "alice"}
+	users.InsertData(obj)
+	_, after, err := users.Patch("Id", 1, map[string]interface{}{"Username": "alicia"})
+	if err != nil || after == obj || after.(*patchTestObj).Username != "alicia" || obj.Username != "alice" {
+		fmt.Println("FAIL: TestPatchPointersAndMaps pointer row", after, err)
+		t.Fail()
+	}
+
+	docs, _ := db.AddTable("docs", "Id", "Address.City")
+	docs.InsertData(map[string]interface{}{"Id": 1, "Address": map[string]interface{}{"City": "Wellington"}})
+	_, _, err = docs.Patch("Id", 1, map[string]interface{}{"Address.City": "Auckland", "Bio": "hi"})
+	doc, _ := docs.LookupKey("Auckland", "Address.City").(map[string]interface{})
+	if err != nil || doc["Bio"] != "hi" || docs.LookupKey("Wellington", "Address.City") != nil {
+		fmt.Println("FAIL: TestPatchPointersAndMaps map row", doc, err)
+		t.Fail()
+	}
+	// with CopyRows the row before is a copy too, so changing it can't reach the one a snapshot still holds
+	copied, _ := db.AddTableWithOptions("copied", sc.TableOptions{PrimaryKey: "Id", CopyRows: true})
+	copied.InsertData(&patchTestObj{Id: 1, Username: "alice"})
+	snap := copied.Snapshot()
+	defer snap.Release()
+	before, _, _ := copied.Patch("Id", 1, map[string]interface{}{"Username": "alicia"})
+	before.(*patchTestObj).Username = "mallory"
+	if row := snap.LookupKey(1, "Id").(*patchTestObj); row.Username != "alice" {
+		fmt.Println("FAIL: TestPatchPointersAndMaps row before shared", row)
+		t.Fail()
+	}
+}
+
+func TestModify(t *testing.T) {
+	table := patchTestTable()
+	_, _, err := table.Modify("Id", 1, func(row interface{}) (interface{}, error) {
+		r := row.(patchTestObj)
+		r.Username = "changed"
+		return r, errors.New("no thanks")
+	})
+	if err == nil || err.Error() != "no thanks" || table.LookupKey("alice", "Username") == nil {
+		fmt.Println("FAIL: TestModify error", err)
+		t.Fail()
+	}
+
+	users, _ := sc.NewTableWithOptions[patchTestObj](sc.InitDb("testdb"), "users", sc.TableOptions{PrimaryKey: "Id"})
+	users.Insert(patchTestObj{Id: 1, Score: 1})
+	for i := 0; i < 3; i++ {
+		users.Modify("Id", 1, func(u *patchTestObj) error {
+			u.Score *= 2
+			return nil
+		})
+	}
+	before, after, err := users.Modify("Id", 1, func(u *patchTestObj) error {
+		u.Score++
+		return nil
+	})
+	if err != nil || before.Score != 8 || after.Score != 9 {
+		fmt.Println("FAIL: TestModify typed", before, after, err)
+		t.Fail()
+	}
+	if _, after, err = users.Patch("Id", 1, map[string]interface{}{"Bio": "hi"}); err != nil || after.Bio != "hi" || after.Score != 9 {
+		fmt.Println("FAIL: TestModify typed patch", after, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,170 @@
+package sc
+
+import (
+	"time"
+)
+
+// Internal identifier of a row. A row keeps its ID from when it is first added until it is removed, however often
+// it is overwritten, so buckets and skip lists can refer to it without going through its primary key
+type rowID uint64
+
+// A row as the table stores it. Each row lives in exactly one record, held in Table.rows, and every index including
+// the primary key points to that record rather than holding the row itself. Overwriting a row swaps the row in its
+// record, so indexes whose keys didn't change don't need touching
+type record struct {
+	id rowID
+	// primary key, normalized the way the primary key index stores it
+	pk interface{}
+	row interface{}
+	// when the row expires, zero if it doesn't
+	expires time.Time
+	// rough size of the row and its index entries, only worked out when the table has MaxBytes
+	size int64
+	// keys the row was stored under by index name, only kept in debug mode, see checkDrift
+	keys map[string][]interface{}
+	// bumped every time the row is written, see Table.Get
+	version uint64
+	// history position just after its old version was last kept, see remember
+	captured uint64
+}
+
+// Put a new row in the row store and give it the next ID. Caller must hold the table write lock
+func (tbl *Table) newRecord(pk, row interface{}) *record {
+	tbl.nextID++
+	rec := &record{id: tbl.nextID, pk: pk, row: row}
+	tbl.bump(rec)
+	tbl.rows[rec.id] = rec
+	tbl.logUndo(undoInsert, rec)
+	return rec
+}
+
+// IDs of every row in the table. Caller must hold the table lock
+func (tbl *Table) rowIDs() []rowID {
+	ids := make([]rowID, 0, len(tbl.rows))
+	for id := range tbl.rows {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Add a new record to every index, including any still being built, under the keys from its field values.
+// Caller must hold the table write lock
+func (tbl *Table) link(rec *record, fields fieldValues) {
+	tbl.logDisplaced(rec, fields)
+	for _, idx := range tbl.Indexes {
+		idx.link(rec, fields)
+	}
+	for _, b := range tbl.building {
+		b.add(rec, fields)
+	}
+}
+
+// Move a record which has just been overwritten from the keys of its old field values to the keys of its new ones.
+// Caller must hold the table write lock
+func (tbl *Table) relink(rec *record, old, fields fieldValues) {
+	tbl.logDisplaced(rec, fields)
+	for _, idx := range tbl.Indexes {
+		idx.relink(rec, old, fields)
+	}
+	for _, b := range tbl.building {
+		b.idx.unlink(rec, old)
+		b.add(rec, fields)
+	}
+}
+
+// Move a record which has just been changed to its new keys, but only in the indexes where they differ from the old
+// ones. Unlike relink a sorted index leaves the row where it is if its key didn't change. Returns the names of the
+// indexes it moved in. Caller must hold the table write lock
+func (tbl *Table) rekey(rec *record, old, fields fieldValues) []string {
+	tbl.logDisplaced(rec, fields)
+	var moved []string
+	for name, idx := range tbl.Indexes {
+		if idx.rekey(rec, old, fields) {
+			moved = append(moved, name)
+		}
+	}
+	for _, b := range tbl.building {
+		if !sameKeys(b.idx.keysOf(old), b.idx.keysOf(fields)) {
+			b.idx.unlink(rec, old)
+			b.add(rec, fields)
+		}
+	}
+	return moved
+}
+
+// Take a record out of every index, including any still being built, using the row's own field values to find its
+// keys. Caller must hold the table write lock
+func (tbl *Table) unlinkRecord(rec *record) {
+	fields := fieldsOf(rec.row)
+	for _, idx := range tbl.Indexes {
+		idx.unlink(rec, fields)
+	}
+	for _, b := range tbl.building {
+		b.idx.unlink(rec, fields)
+	}
+}
+
+// The record stored under key in the primary key index, or any other unique index. key must already be normalized
+// and hashable
+func (idx Index) get(key interface{}) (*record, bool) {
+	rec, ok := idx.Idx[key].(*record)
+	return rec, ok
+}
+
+// Store a record under every key it has in this index
+func (idx Index) link(rec *record, fields fieldValues) {
+	for _, key := range idx.keysOf(fields) {
+		idx.put(key, rec)
+	}
+}
+
+// Take a record out from under the keys it had with the given field values.
+// A unique key is only removed if it still belongs to this record, ie. it wasn't overwritten by another row since.
+// A sorted index holds every record in its skip list whatever happened to the unique key so it always drops it there
+func (idx Index) unlink(rec *record, fields fieldValues) {
+	if idx.sorted != nil {
+		idx.sorted.remove(rec.id)
+	}
+	for _, key := range idx.keysOf(fields) {
+		idx.remove(key, rec)
+	}
+}
+
+// Move a record from its old keys to its new ones. When they are the same the record is already in place, it is only
+// stored again in case another row took over a unique key since. Sorted and ordered indexes always move it so that
+// it ranks as the latest write among rows with the same key
+func (idx Index) relink(rec *record, old, fields fieldValues) {
+	keys := idx.keysOf(fields)
+	if idx.sorted != nil || !sameKeys(idx.keysOf(old), keys) {
+		idx.unlink(rec, old)
+	}
+	for _, key := range keys {
+		idx.put(key, rec)
+	}
+}
+
+// Move a record from its old keys to its new ones if they are different. Returns whether it moved
+func (idx Index) rekey(rec *record, old, fields fieldValues) bool {
+	keys := idx.keysOf(fields)
+	if sameKeys(idx.keysOf(old), keys) {
+		return false
+	}
+	idx.unlink(rec, old)
+	for _, key := range keys {
+		idx.put(key, rec)
+	}
+	return true
+}
+
+// Whether two lists of keys from keysOf are the same, in the same order. Keys which can't be compared never match
+func sameKeys(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !hashable(a[i]) || !hashable(b[i]) || a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"runtime"
+)
+
+// Big enough that storing a copy of it per index would stand out
+type rowsTestObj struct {
+	Id int
+	A, B, C, D, E, F int
+	Payload [1024]byte
+}
+
+// Live heap per row in a table of value rows, indexed on the given fields
+func heapPerRow(fields... string) float64 {
+	const rowCount = 5000
+	before := heapInUse()
+	table, _ := sc.InitDb("testdb").AddTable("testTable", fields...)
+	for i := 0; i < rowCount; i++ {
+		table.SetData(rowsTestObj{Id: i, A: i, B: i, C: i, D: i, E: i, F: i})
+	}
+	perRow := float64(heapInUse() - before) / rowCount
+	runtime.KeepAlive(table)
+	return perRow
+}
+
+// Each row is stored once however many indexes it is in, so an extra index costs a key and a map entry rather than
+// another copy of the row
+func TestRowStoredOnce(t *testing.T) {
+	one := heapPerRow("Id")
+	seven := heapPerRow("Id", "A", "B", "C", "D", "E", "F")
+	if perIndex := (seven - one) / 6; perIndex > 256 {
+		fmt.Printf("FAIL: TestRowStoredOnce %.0f bytes per row per extra index (%.0f vs %.0f per row)\n", perIndex, seven, one)
+		t.Fail()
+	}
+}
+
+func TestRowAllocs(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"A": {Unique: true}, "B": {}, "C": {Kind: sc.SortedIndex}},
+	}
+	table, _ := db.AddTableWithOptions("testTable", opts)
+	for i := 0; i < 100; i++ {
+		table.SetData(&rowsTestObj{Id: i, A: i, B: i % 10, C: i})
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { table.LookupKey(50, "Id") }); allocs != 0 {
+		fmt.Println("FAIL: TestRowAllocs LookupKey allocates", allocs)
+		t.Fail()
+	}
+	if allocs := testing.AllocsPerRun(100, func() { table.Rank("C", 50) }); allocs != 0 {
+		fmt.Println("FAIL: TestRowAllocs Rank allocates", allocs)
+		t.Fail()
+	}
+
+	// overwriting a row reuses its record, which is cheaper than taking it out and putting a new one in
+	row := &rowsTestObj{Id: 5, A: 5, B: 5, C: 5}
+	overwrite := testing.AllocsPerRun(100, func() { table.SetData(row) })
+	replace := testing.AllocsPerRun(100, func() {
+		table.Delete("Id", 5)
+		table.InsertData(row)
+	})
+	if overwrite >= replace {
+		fmt.Println("FAIL: TestRowAllocs overwrite", overwrite, "vs delete and insert", replace)
+		t.Fail()
+	}
+	if sc.StoredRows(table) != 100 || table.LookupKey(5, "A") != row || len(table.LookupAll("B", 5)) != 10 {
+		fmt.Println("FAIL: TestRowAllocs rows after overwriting", sc.StoredRows(table))
+		t.Fail()
+	}
+}
+
+// An overwritten row keeps its place in every index whose key didn't change and moves in the ones where it did
+func TestRowOverwrite(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"A": {Unique: true}, "B": {}},
+	}
+	table, _ := db.AddTableWithOptions("testTable", opts)
+	table.SetData(rowsTestObj{Id: 1, A: 1, B: 1}, rowsTestObj{Id: 2, A: 2, B: 1})
+
+	table.SetData(rowsTestObj{Id: 1, A: 10, B: 1})
+	if table.LookupKey(1, "A") != nil || table.LookupKey(10, "A").(rowsTestObj).Id != 1 {
+		fmt.Println("FAIL: TestRowOverwrite unique key not moved")
+		t.Fail()
+	}
+	if rows := table.LookupAll("B", 1); len(rows) != 2 {
+		fmt.Println("FAIL: TestRowOverwrite bucket", rows)
+		t.Fail()
+	}
+	table.SetData(rowsTestObj{Id: 1, A: 10, B: 2})
+	if len(table.LookupAll("B", 1)) != 1 || len(table.LookupAll("B", 2)) != 1 || sc.StoredRows(table) != 2 {
+		fmt.Println("FAIL: TestRowOverwrite bucket not moved")
+		t.Fail()
+	}
+	if row, err := table.Delete("A", 10); err != nil || row.(rowsTestObj).B != 2 || sc.StoredRows(table) != 1 ||
+		len(table.LookupAll("B", 2)) != 0 {
+		fmt.Println("FAIL: TestRowOverwrite delete", row, err)
+		t.Fail()
+	}
+}
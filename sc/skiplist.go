@@ -0,0 +1,233 @@
+package sc
+
+import (
+	"math/rand"
+)
+
+// Same parameters as the Redis sorted set skip list
+const skipMaxLevel = 32
+const skipP = 0.25
+
+// Skip list of rows ordered by key, with spans on every link so the rank of a row and the row at a given rank can
+// both be found in O(log n). Rows with equal keys are kept in the order they went in.
+// Not safe for concurrent use, the table lock guards it like everything else in an index
+type skipList struct {
+	head *skipNode
+	tail *skipNode
+	length int
+	level int
+	cmp func(a, b interface{}) int
+	// every row's node by row ID so removing a row or reading its key is O(1) to find
+	nodes map[rowID]*skipNode
+	// insertion counter used to order rows with the same key
+	seq uint64
+}
+
+type skipNode struct {
+	key interface{}
+	seq uint64
+	rec *record
+	prev *skipNode
+	levels []skipLevel
+}
+
+type skipLevel struct {
+	next *skipNode
+	// how many nodes along the bottom level this link skips over
+	span int
+}
+
+func newSkipList(cmp func(a, b interface{}) int) *skipList {
+	return &skipList{
+		head: &skipNode{levels: make([]skipLevel, skipMaxLevel)},
+		level: 1,
+		cmp: cmp,
+		nodes: make(map[rowID]*skipNode),
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipMaxLevel && rand.Float64() < skipP {
+		level++
+	}
+	return level
+}
+
+// Whether n sorts before the position of (key, seq)
+func (sl *skipList) before(n *skipNode, key interface{}, seq uint64) bool {
+	c := sl.cmp(n.key, key)
+	return c < 0 || (c == 0 && n.seq < seq)
+}
+
+// Add a row under key, replacing any node it already has
+func (sl *skipList) insert(key interface{}, rec *record) {
+	sl.remove(rec.id)
+	sl.seq++
+	sl.insertAt(key, sl.seq, rec)
+}
+
+// Add a row at the position of (key, seq), which no other node may have. Lets a snapshot put old versions of rows
+// back where they were among rows with the same key
+func (sl *skipList) insertAt(key interface{}, seq uint64, rec *record) {
+	var update [skipMaxLevel]*skipNode
+	var rank [skipMaxLevel]int
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level - 1 {
+			rank[i] = rank[i + 1]
+		}
+		for x.levels[i].next != nil && sl.before(x.levels[i].next, key, seq) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.head
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+	n := &skipNode{key: key, seq: seq, rec: rec, levels: make([]skipLevel, level)}
+	for i := 0; i < level; i++ {
+		n.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = n
+		n.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.head {
+		n.prev = update[0]
+	}
+	if n.levels[0].next != nil {
+		n.levels[0].next.prev = n
+	} else {
+		sl.tail = n
+	}
+	sl.length++
+	sl.nodes[rec.id] = n
+}
+
+// Take the row with the given ID out of the list if it's there
+func (sl *skipList) remove(id rowID) {
+	n, ok := sl.nodes[id]
+	if !ok {
+		return
+	}
+	var update [skipMaxLevel]*skipNode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && sl.before(x.levels[i].next, n.key, n.seq) {
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].next == n {
+			update[i].levels[i].span += n.levels[i].span - 1
+			update[i].levels[i].next = n.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if n.levels[0].next != nil {
+		n.levels[0].next.prev = n.prev
+	} else {
+		sl.tail = n.prev
+	}
+	for sl.level > 1 && sl.head.levels[sl.level - 1].next == nil {
+		sl.level--
+	}
+	sl.length--
+	delete(sl.nodes, id)
+}
+
+// First node at or after the position of (key, seq), or nil if there isn't one, and how many nodes come before it
+func (sl *skipList) seek(key interface{}, seq uint64) (*skipNode, int) {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && sl.before(x.levels[i].next, key, seq) {
+			rank += x.levels[i].span
+			x = x.levels[i].next
+		}
+	}
+	return x.levels[0].next, rank
+}
+
+// 0 based position of the row with the given ID
+func (sl *skipList) rank(id rowID) (int, bool) {
+	n, ok := sl.nodes[id]
+	if !ok {
+		return 0, false
+	}
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && (x.levels[i].next == n || sl.before(x.levels[i].next, n.key, n.seq)) {
+			rank += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if x == n {
+			return rank - 1, true
+		}
+	}
+	return 0, false
+}
+
+// Node at a 0 based position, or nil if there isn't one
+func (sl *skipList) byRank(rank int) *skipNode {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+	target := rank + 1
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed + x.levels[i].span <= target {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if traversed == target {
+			return x
+		}
+	}
+	return nil
+}
+
+// First node with a key >= key, or nil if there isn't one
+func (sl *skipList) firstFrom(key interface{}) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && sl.cmp(x.levels[i].next.key, key) < 0 {
+			x = x.levels[i].next
+		}
+	}
+	return x.levels[0].next
+}
+
+// Last node with a key <= key, or < key if inclusive is false. nil if there isn't one
+func (sl *skipList) lastUpTo(key interface{}, inclusive bool) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil {
+			c := sl.cmp(x.levels[i].next.key, key)
+			if c > 0 || (c == 0 && !inclusive) {
+				break
+			}
+			x = x.levels[i].next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
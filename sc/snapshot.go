@@ -0,0 +1,766 @@
+package sc
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Read only view of a table as it was when Table.Snapshot was called. Writes to the table afterwards don't show up
+// in it, so it can be read for as long as needed, eg. to page through a big table while it's being written to.
+// Nothing is copied when one is taken. Instead, while any snapshot of a table is live, the first write to a row
+// after the newest snapshot keeps the row's old version in the table's history, and a snapshot reads the live
+// indexes while skipping rows changed since, plus the old versions of those rows. So a snapshot costs memory in
+// proportion to the rows written while it's live, and writers pay a little extra for each row they change for the
+// first time. Reads take the table's read lock like a table read does, so a snapshot can't be read from inside Update
+// or View. Rows are expired or not as of when the snapshot was taken.
+// Release it when done so the old versions can be garbage collected. Safe for concurrent use
+type TableSnapshot struct {
+	mu sync.Mutex
+	// the table, nil once released
+	tbl *Table
+	// position in the table's history when taken, writes from there on are hidden
+	seq uint64
+	// how far through the history old versions have been picked up
+	synced uint64
+	// when it was taken
+	at time.Time
+	// the row store, rows with an expiry time and indexes as they were. These are the table's own maps, which get
+	// written to, unless CleanTableData or DropIndex has let go of them since
+	rows map[rowID]*record
+	expiring map[rowID]*record
+	indexes map[string]Index
+	// how many rows there were and the last row ID handed out
+	count int
+	maxID rowID
+	// every record written since, mapped to its old version, or to nil if it didn't exist yet
+	old map[*record]*record
+	// the old versions by row ID, and by key in empty copies of the indexes
+	ghosts map[rowID]*record
+	ghostIdx map[string]Index
+}
+
+// Read only view of every table in a db as of the same moment, see Database.Snapshot
+type Snapshot struct {
+	tables map[string]*TableSnapshot
+}
+
+// The old version of a row, kept by the first write to it after a snapshot was taken
+type rowVersion struct {
+	rec *record
+	// copy of the record before the write, nil if the write created it
+	was *record
+	// keys it was stored under by index name. Not always all the keys for its row, since other rows can take unique
+	// keys off it
+	keys map[string][]interface{}
+	// key and insertion counter of its node in each sorted or ordered index, so it keeps its place among rows with
+	// the same key
+	nodes map[string]skipPos
+}
+
+type skipPos struct {
+	key interface{}
+	seq uint64
+}
+
+// How many rows each turn of Scan reads before letting writers in
+const scanChunk = 1000
+
+// Take a snapshot of the table, see TableSnapshot
+func (tbl *Table) Snapshot() *TableSnapshot {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.snapshot()
+}
+
+// Take a snapshot of every table in the db at once, so rows in different tables are consistent with each other.
+// Waits for any Update to finish first. Tables added afterwards aren't in it
+func (db *Database) Snapshot() *Snapshot {
+	snap := &Snapshot{tables: make(map[string]*TableSnapshot)}
+	db.View(func(tx *Tx) error {
+		for name, tbl := range tx.tables {
+			snap.tables[name] = tbl.snapshot()
+		}
+		return nil
+	})
+	return snap
+}
+
+// Does the work of Snapshot. O(number of indexes). Caller must hold the table lock
+func (tbl *Table) snapshot() *TableSnapshot {
+	tbl.snapMu.Lock()
+	defer tbl.snapMu.Unlock()
+	seq := tbl.histBase + uint64(len(tbl.history))
+	ts := &TableSnapshot{
+		tbl: tbl,
+		seq: seq,
+		synced: seq,
+		at: time.Now(),
+		rows: tbl.rows,
+		expiring: tbl.expiring,
+		indexes: make(map[string]Index, len(tbl.Indexes)),
+		count: len(tbl.rows),
+		maxID: tbl.nextID,
+		old: make(map[*record]*record),
+		ghosts: make(map[rowID]*record),
+		ghostIdx: make(map[string]Index, len(tbl.Indexes)),
+	}
+	for name, idx := range tbl.Indexes {
+		ts.indexes[name] = idx
+		ts.ghostIdx[name] = idx.empty()
+	}
+	tbl.snapshots = append(tbl.snapshots, seq)
+	tbl.db.logger.Debug("snapshot", "table", tbl.Name, "rows", len(tbl.rows))
+	return ts
+}
+
+// Keep the old version of a record which is about to be written, or note that it's new, if a live snapshot needs
+// it. Only the first write after the newest snapshot was taken is kept, older snapshots share the same version.
+// Caller must hold the table write lock
+func (tbl *Table) remember(rec *record, created bool) {
+	if len(tbl.snapshots) == 0 || rec.captured > tbl.snapshots[len(tbl.snapshots) - 1] {
+		return
+	}
+	v := rowVersion{rec: rec}
+	if !created {
+		v = tbl.versionOf(rec)
+	}
+	tbl.history = append(tbl.history, v)
+	rec.captured = tbl.histBase + uint64(len(tbl.history))
+}
+
+// Keep the old version of every row, before a change which reaches them all without writing to them.
+// Caller must hold the table write lock
+func (tbl *Table) rememberAll() {
+	if len(tbl.snapshots) == 0 {
+		return
+	}
+	for _, rec := range tbl.rows {
+		tbl.remember(rec, false)
+	}
+}
+
+// Copy of a record as it is now and where it is in every index. Caller must hold the table lock
+func (tbl *Table) versionOf(rec *record) rowVersion {
+	was := *rec
+	v := rowVersion{rec: rec, was: &was, keys: make(map[string][]interface{}, len(tbl.Indexes))}
+	fields := fieldsOf(rec.row)
+	for name, idx := range tbl.Indexes {
+		for _, key := range idx.keysOf(fields) {
+			if !idx.Unique || idx.Idx[key] == rec {
+				v.keys[name] = append(v.keys[name], key)
+			}
+		}
+	}
+	v.nodes = tbl.nodesOf(rec)
+	return v
+}
+
+// Key and insertion counter of a record's node in each sorted or ordered index, or nil if there aren't any.
+// Caller must hold the table lock
+func (tbl *Table) nodesOf(rec *record) map[string]skipPos {
+	var nodes map[string]skipPos
+	for name, idx := range tbl.Indexes {
+		if idx.sorted == nil {
+			continue
+		}
+		if n, ok := idx.sorted.nodes[rec.id]; ok {
+			if nodes == nil {
+				nodes = make(map[string]skipPos)
+			}
+			nodes[name] = skipPos{n.key, n.seq}
+		}
+	}
+	return nodes
+}
+
+// Drop a released snapshot, along with the old versions no live snapshot needs any more.
+// Caller must hold the table write lock
+func (tbl *Table) forget(seq uint64) {
+	for i, s := range tbl.snapshots {
+		if s == seq {
+			tbl.snapshots = append(tbl.snapshots[:i], tbl.snapshots[i + 1:]...)
+			break
+		}
+	}
+	if len(tbl.snapshots) == 0 {
+		tbl.histBase += uint64(len(tbl.history))
+		tbl.history = nil
+		return
+	}
+	if oldest := tbl.snapshots[0]; oldest > tbl.histBase {
+		tbl.history = append([]rowVersion(nil), tbl.history[oldest - tbl.histBase:]...)
+		tbl.histBase = oldest
+	}
+}
+
+// Let go of the snapshot so the old versions it was keeping can be garbage collected. Reads afterwards find nothing,
+// or fail with ErrReleased. Waits for any write in progress. Safe to call more than once
+func (ts *TableSnapshot) Release() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.tbl == nil {
+		return
+	}
+	ts.tbl.mu.Lock()
+	ts.tbl.forget(ts.seq)
+	ts.tbl.mu.Unlock()
+	ts.tbl = nil
+	ts.rows, ts.expiring, ts.indexes = nil, nil, nil
+	ts.old, ts.ghosts, ts.ghostIdx = nil, nil, nil
+}
+
+// Run fn with the snapshot caught up on the old versions written since it was last read, holding the table read
+// lock, or fail with ErrReleased
+func (ts *TableSnapshot) read(fn func(tbl *Table) error) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.tbl == nil {
+		return errors.Wrap(ErrReleased, "Table snapshot")
+	}
+	ts.tbl.mu.RLock()
+	defer ts.tbl.mu.RUnlock()
+	ts.sync()
+	return fn(ts.tbl)
+}
+
+// Pick up old versions of rows written since the snapshot was last read. The first one for each row is the one it
+// had when the snapshot was taken. Caller must hold ts.mu and the table lock
+func (ts *TableSnapshot) sync() {
+	tbl := ts.tbl
+	for end := tbl.histBase + uint64(len(tbl.history)); ts.synced < end; ts.synced++ {
+		v := tbl.history[ts.synced - tbl.histBase]
+		if _, ok := ts.old[v.rec]; ok {
+			continue
+		}
+		ts.old[v.rec] = v.was
+		if v.was == nil {
+			continue
+		}
+		ts.ghosts[v.was.id] = v.was
+		for name, idx := range ts.ghostIdx {
+			for _, key := range v.keys[name] {
+				idx.store(key, v.was)
+			}
+			if pos, ok := v.nodes[name]; ok {
+				idx.sorted.insertAt(pos.key, pos.seq, v.was)
+			}
+		}
+	}
+}
+
+// Whether rec has been written since the snapshot was taken, so the live indexes don't have it as it was
+func (ts *TableSnapshot) changed(rec *record) bool {
+	_, ok := ts.old[rec]
+	return ok
+}
+
+// The unexpired records under key in an index as they were. Caller must hold ts.mu and the table lock
+func (ts *TableSnapshot) lookup(index string, key interface{}) []*record {
+	var recs []*record
+	for _, rec := range ts.indexes[index].lookup(key) {
+		if !ts.changed(rec) && !ts.tbl.expired(rec, ts.at) {
+			recs = append(recs, rec)
+		}
+	}
+	for _, rec := range ts.ghostIdx[index].lookup(key) {
+		if !ts.tbl.expired(rec, ts.at) {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// The record with row ID id as it was, nil if there wasn't one. Caller must hold ts.mu and the table lock
+func (ts *TableSnapshot) record(id rowID) *record {
+	if rec, ok := ts.rows[id]; ok && !ts.changed(rec) {
+		return rec
+	}
+	return ts.ghosts[id]
+}
+
+// Name of the table the snapshot was taken of
+func (ts *TableSnapshot) Name() string {
+	name := ""
+	ts.read(func(tbl *Table) error {
+		name = tbl.Name
+		return nil
+	})
+	return name
+}
+
+// How many unexpired rows were in the table
+func (ts *TableSnapshot) Len() int {
+	n := 0
+	ts.read(func(tbl *Table) error {
+		n = ts.count
+		for _, rec := range ts.expiring {
+			if !ts.changed(rec) && tbl.expired(rec, ts.at) {
+				n--
+			}
+		}
+		for _, rec := range ts.ghosts {
+			if tbl.expired(rec, ts.at) {
+				n--
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+// Same as Table.LookupKey
+func (ts *TableSnapshot) LookupKey(key interface{}, idx string) interface{} {
+	var row interface{}
+	ts.read(func(tbl *Table) error {
+		if recs := ts.lookup(idx, key); len(recs) > 0 {
+			row = tbl.rowOut(recs[0])
+		}
+		return nil
+	})
+	return row
+}
+
+// Same as Table.LookupAll
+func (ts *TableSnapshot) LookupAll(idx string, key interface{}) []interface{} {
+	var rows []interface{}
+	ts.read(func(tbl *Table) error {
+		recs := ts.lookup(idx, key)
+		rows = make([]interface{}, len(recs))
+		for i, rec := range recs {
+			rows[i] = tbl.rowOut(rec)
+		}
+		return nil
+	})
+	return rows
+}
+
+// Same as Table.Get, the version being the one the row had when the snapshot was taken
+func (ts *TableSnapshot) Get(index string, key interface{}) (row interface{}, version uint64, ok bool) {
+	ts.read(func(tbl *Table) error {
+		if recs := ts.lookup(index, key); len(recs) > 0 {
+			row, version, ok = tbl.rowOut(recs[0]), recs[0].version, true
+		}
+		return nil
+	})
+	return row, version, ok
+}
+
+// Call fn with every row in the order they were first inserted, until it returns false. Rows are read a chunk at a
+// time and fn is called without any lock held, so it can use the table
+func (ts *TableSnapshot) Scan(fn func(row interface{}) bool) error {
+	var ids []rowID
+	err := ts.read(func(tbl *Table) error {
+		ids = make([]rowID, 0, ts.count)
+		for id, rec := range ts.rows {
+			if id <= ts.maxID && !ts.changed(rec) {
+				ids = append(ids, id)
+			}
+		}
+		for id := range ts.ghosts {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > scanChunk {
+			chunk = chunk[:scanChunk]
+		}
+		ids = ids[len(chunk):]
+		var rows []interface{}
+		err := ts.read(func(tbl *Table) error {
+			for _, id := range chunk {
+				if rec := ts.record(id); rec != nil && !tbl.expired(rec, ts.at) {
+					rows = append(rows, tbl.rowOut(rec))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if !fn(row) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// The skip lists of a sorted or ordered index as it was: the live one, whose rows written since are hidden, and the
+// one holding their old versions. Caller must hold ts.mu and the table lock
+func (ts *TableSnapshot) skipLists(index string, kind IndexKind) (live, old *skipList, err error) {
+	live, err = skipListIn(ts.indexes, ts.tbl.Name, index, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	return live, ts.ghostIdx[index].sorted, nil
+}
+
+// Steps through an index as it was, merging the unchanged nodes of its live skip list with the old versions
+type snapCursor struct {
+	ts *TableSnapshot
+	live, old *skipNode
+	cmp func(a, b interface{}) int
+	desc bool
+}
+
+// A cursor starting from a node in each list, which are nil if there's nothing left in that one
+func (ts *TableSnapshot) cursor(sl *skipList, live, old *skipNode, desc bool) *snapCursor {
+	return &snapCursor{ts: ts, live: live, old: old, cmp: sl.cmp, desc: desc}
+}
+
+// The next node in the index as it was, or nil at the end
+func (c *snapCursor) next() *skipNode {
+	for c.live != nil && c.ts.changed(c.live.rec) {
+		c.live = c.step(c.live)
+	}
+	var n *skipNode
+	switch {
+	case c.live == nil && c.old == nil:
+		return nil
+	case c.old == nil || (c.live != nil && c.first(c.live, c.old)):
+		n, c.live = c.live, c.step(c.live)
+	default:
+		n, c.old = c.old, c.step(c.old)
+	}
+	return n
+}
+
+func (c *snapCursor) step(n *skipNode) *skipNode {
+	if c.desc {
+		return n.prev
+	}
+	return n.levels[0].next
+}
+
+// Whether a comes before b in the direction the cursor goes. Only a row and its own old version share a position
+func (c *snapCursor) first(a, b *skipNode) bool {
+	x := c.cmp(a.key, b.key)
+	before := x < 0 || (x == 0 && a.seq < b.seq)
+	return before != c.desc
+}
+
+// Same as Table.walk for a cursor. Caller must hold ts.mu and the table lock
+func (ts *TableSnapshot) walk(c *snapCursor, within func(key interface{}) bool, opts RangeOptions) []interface{} {
+	var rows []interface{}
+	skip := opts.Offset
+	for n := c.next(); n != nil && within(n.key); n = c.next() {
+		if ts.tbl.expired(n.rec, ts.at) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		rows = append(rows, ts.tbl.rowOut(n.rec))
+		if opts.Limit > 0 && len(rows) == opts.Limit {
+			break
+		}
+	}
+	return rows
+}
+
+// How many nodes of the live list are hidden, ie. their rows have been written since. Only those sorting before
+// (key, seq) if before is set. Caller must hold ts.mu and the table lock
+func (ts *TableSnapshot) hidden(live *skipList, before *skipPos) int {
+	count := 0
+	for rec := range ts.old {
+		if n, ok := live.nodes[rec.id]; ok && n.rec == rec && (before == nil || live.before(n, before.key, before.seq)) {
+			count++
+		}
+	}
+	return count
+}
+
+// Number of rows in a sorted index as it was
+func (ts *TableSnapshot) length(live, old *skipList) int {
+	return live.length - ts.hidden(live, nil) + old.length
+}
+
+// 0 based position of a node in the index as it was, n being in either list
+func (ts *TableSnapshot) rank(live, old *skipList, n *skipNode) int {
+	_, liveBefore := live.seek(n.key, n.seq)
+	_, oldBefore := old.seek(n.key, n.seq)
+	return liveBefore - ts.hidden(live, &skipPos{n.key, n.seq}) + oldBefore
+}
+
+// Node at a 0 based position in the index as it was, or nil if there isn't one. Goes through the hidden live nodes
+// and old versions in order, counting the visible live nodes between them, so O(c log n) for c rows written since
+func (ts *TableSnapshot) byRank(live, old *skipList, rank int) *skipNode {
+	type mark struct {
+		// live nodes before it
+		before int
+		// nil for a hidden live node
+		ghost *skipNode
+	}
+	var marks []mark
+	for rec := range ts.old {
+		if n, ok := live.nodes[rec.id]; ok && n.rec == rec {
+			_, before := live.seek(n.key, n.seq)
+			marks = append(marks, mark{before, nil})
+		}
+	}
+	for n := old.head.levels[0].next; n != nil; n = n.levels[0].next {
+		_, before := live.seek(n.key, n.seq)
+		marks = append(marks, mark{before, n})
+	}
+	// an old version comes before the live node at the same count, and keeps its order among the other old ones
+	sort.SliceStable(marks, func(i, j int) bool {
+		if marks[i].before != marks[j].before {
+			return marks[i].before < marks[j].before
+		}
+		return marks[i].ghost != nil && marks[j].ghost == nil
+	})
+	seen, liveSeen := 0, 0
+	for _, m := range marks {
+		visible := m.before - liveSeen
+		if rank < seen + visible {
+			break
+		}
+		seen += visible
+		liveSeen = m.before
+		if m.ghost == nil {
+			liveSeen++
+			continue
+		}
+		if seen == rank {
+			return m.ghost
+		}
+		seen++
+	}
+	return live.byRank(liveSeen + rank - seen)
+}
+
+// The node of the unexpired row with primary key pk as it was. Caller must hold ts.mu and the table lock
+func (ts *TableSnapshot) nodeOf(live, old *skipList, pk interface{}) (*skipNode, bool) {
+	recs := ts.lookup(ts.tbl.pk, pk)
+	if len(recs) == 0 {
+		return nil, false
+	}
+	if ts.ghosts[recs[0].id] == recs[0] {
+		n, ok := old.nodes[recs[0].id]
+		return n, ok
+	}
+	n, ok := live.nodes[recs[0].id]
+	return n, ok
+}
+
+// Same as Table.Range
+func (ts *TableSnapshot) Range(index string, lo, hi interface{}, opts RangeOptions) ([]interface{}, error) {
+	var rows []interface{}
+	err := ts.read(func(tbl *Table) error {
+		live, old, err := ts.skipLists(index, OrderedIndex)
+		if err != nil {
+			return err
+		}
+		// keys are all of one sort, so either list can check the bounds against them
+		sl := live
+		if sl.length == 0 {
+			sl = old
+		}
+		idx := ts.indexes[index]
+		var loKey, hiKey interface{}
+		if lo != nil {
+			if loKey, err = sl.bound(index, idx.normalize(lo)); err != nil {
+				return err
+			}
+		}
+		if hi != nil {
+			if hiKey, err = sl.bound(index, idx.normalize(hi)); err != nil {
+				return err
+			}
+		}
+
+		var c *snapCursor
+		var within func(key interface{}) bool
+		if opts.Desc {
+			c = ts.cursor(sl, live.tail, old.tail, true)
+			if hi != nil {
+				c = ts.cursor(sl, live.lastUpTo(hiKey, true), old.lastUpTo(hiKey, true), true)
+			}
+			within = func(key interface{}) bool { return lo == nil || sl.cmp(key, loKey) >= 0 }
+		} else {
+			c = ts.cursor(sl, live.head.levels[0].next, old.head.levels[0].next, false)
+			if lo != nil {
+				c = ts.cursor(sl, live.firstFrom(loKey), old.firstFrom(loKey), false)
+			}
+			within = func(key interface{}) bool { return hi == nil || sl.cmp(key, hiKey) <= 0 }
+		}
+		rows = ts.walk(c, within, opts)
+		return nil
+	})
+	return rows, err
+}
+
+// Same as Table.Prefix
+func (ts *TableSnapshot) Prefix(index string, prefix string, opts RangeOptions) ([]interface{}, error) {
+	var rows []interface{}
+	err := ts.read(func(tbl *Table) error {
+		live, old, err := ts.skipLists(index, OrderedIndex)
+		if err != nil {
+			return err
+		}
+		sl := live
+		if sl.length == 0 {
+			sl = old
+		}
+		if _, err = sl.bound(index, prefix); err != nil {
+			return err
+		}
+		if ts.indexes[index].Lower {
+			prefix = strings.ToLower(prefix)
+		}
+
+		c := ts.cursor(sl, live.firstFrom(prefix), old.firstFrom(prefix), false)
+		if opts.Desc {
+			c = ts.cursor(sl, live.tail, old.tail, true)
+			if end, ok := prefixEnd(prefix); ok {
+				c = ts.cursor(sl, live.lastUpTo(end, false), old.lastUpTo(end, false), true)
+			}
+		}
+		within := func(key interface{}) bool { return strings.HasPrefix(key.(string), prefix) }
+		rows = ts.walk(c, within, opts)
+		return nil
+	})
+	return rows, err
+}
+
+// Same as Table.Rank. O(c + log n) for c rows written since
+func (ts *TableSnapshot) Rank(index string, key interface{}) (rank int, ok bool) {
+	ts.read(func(tbl *Table) error {
+		live, old, err := ts.skipLists(index, SortedIndex)
+		if err != nil {
+			return err
+		}
+		var n *skipNode
+		if n, ok = ts.nodeOf(live, old, key); ok {
+			rank = ts.rank(live, old, n)
+		}
+		return nil
+	})
+	return rank, ok
+}
+
+// Same as Table.Score
+func (ts *TableSnapshot) Score(index string, key interface{}) (score float64, ok bool) {
+	ts.read(func(tbl *Table) error {
+		live, old, err := ts.skipLists(index, SortedIndex)
+		if err != nil {
+			return err
+		}
+		var n *skipNode
+		if n, ok = ts.nodeOf(live, old, key); ok {
+			score = n.key.(float64)
+		}
+		return nil
+	})
+	return score, ok
+}
+
+// Same as Table.RangeByRank. O(c log n + m) for c rows written since and m rows returned
+func (ts *TableSnapshot) RangeByRank(index string, start, stop int) ([]interface{}, error) {
+	var rows []interface{}
+	err := ts.read(func(tbl *Table) error {
+		live, old, err := ts.skipLists(index, SortedIndex)
+		if err != nil {
+			return err
+		}
+		length := ts.length(live, old)
+		if start < 0 {
+			start += length
+		}
+		if stop < 0 {
+			stop += length
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= length {
+			stop = length - 1
+		}
+		rows = []interface{}{}
+		first := ts.byRank(live, old, start)
+		if first == nil || start > stop {
+			return nil
+		}
+		liveFrom, _ := live.seek(first.key, first.seq)
+		oldFrom, _ := old.seek(first.key, first.seq)
+		c := ts.cursor(live, liveFrom, oldFrom, false)
+		for i, n := start, c.next(); i <= stop && n != nil; i, n = i + 1, c.next() {
+			if !tbl.expired(n.rec, ts.at) {
+				rows = append(rows, tbl.rowOut(n.rec))
+			}
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// Same as Table.RangeByScore
+func (ts *TableSnapshot) RangeByScore(index string, min, max float64) ([]interface{}, error) {
+	var rows []interface{}
+	err := ts.read(func(tbl *Table) error {
+		live, old, err := ts.skipLists(index, SortedIndex)
+		if err != nil {
+			return err
+		}
+		c := ts.cursor(live, live.firstFrom(min), old.firstFrom(min), false)
+		within := func(key interface{}) bool { return key.(float64) <= max }
+		rows = ts.walk(c, within, RangeOptions{})
+		if rows == nil {
+			rows = []interface{}{}
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// Same as Table.Dump. Holds the table read lock throughout, like Table.Dump
+func (ts *TableSnapshot) Dump(w io.Writer, format DumpFormat) error {
+	return ts.read(func(tbl *Table) error {
+		indexes := make(map[string]Index, len(ts.indexes))
+		for name, idx := range ts.indexes {
+			merged := idx
+			merged.Idx = make(map[interface{}]interface{}, len(idx.Idx))
+			merged.sorted = nil
+			for key := range idx.Idx {
+				for _, rec := range idx.lookup(key) {
+					if !ts.changed(rec) {
+						merged.store(key, rec)
+					}
+				}
+			}
+			for key := range ts.ghostIdx[name].Idx {
+				for _, rec := range ts.ghostIdx[name].lookup(key) {
+					merged.store(key, rec)
+				}
+			}
+			indexes[name] = merged
+		}
+		return tbl.dump(w, format, indexes, ts.at)
+	})
+}
+
+// Get a table in the snapshot by name. ok is false if there was no such table when the snapshot was taken
+func (s *Snapshot) Table(name string) (*TableSnapshot, bool) {
+	ts, ok := s.tables[name]
+	return ts, ok
+}
+
+// Release every table in the snapshot, see TableSnapshot.Release
+func (s *Snapshot) Release() {
+	for _, ts := range s.tables {
+		ts.Release()
+	}
+}
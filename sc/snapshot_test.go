@@ -0,0 +1,353 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type snapTestObj struct {
+	Id int
+	Username string
+	Country string
+	Score int
+	Created time.Time
+}
+
+func snapTestTable(db *sc.Database) *sc.Table {
+	opts := sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{
+			"Username": {Unique: true},
+			"Country": {},
+			"Score": {Kind: sc.SortedIndex},
+			"Created": {Kind: sc.OrderedIndex},
+		},
+	}
+	table, _ := db.AddTableWithOptions("users", opts)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 100; i++ {
+		table.InsertData(snapTestObj{i, fmt.Sprint("user", i), []string{"NZ", "US"}[i % 2], i % 10, start.Add(time.Duration(i) * time.Hour)})
+	}
+	return table
+}
+
+// Nothing written after the snapshot shows up in it
+func TestTableSnapshot(t *testing.T) {
+	table := snapTestTable(sc.InitDb("testdb"))
+	snap := table.Snapshot()
+	_, version, _ := table.Get("Id", 1)
+
+	table.UpdateData(snapTestObj{Id: 1, Username: "renamed", Country: "AU", Score: 99})
+	table.Delete("Id", 2)
+	table.InsertData(snapTestObj{Id: 101, Username: "new", Country: "NZ", Score: 1})
+
+	if snap.Len() != 100 || sc.GetTableSize(table) != 100 || snap.Name() != "users" {
+		fmt.Println("FAIL: TestTableSnapshot size", snap.Len())
+		t.Fail()
+	}
+	row, v, ok := snap.Get("Username", "user1")
+	if !ok || v != version || row.(snapTestObj).Score != 1 || snap.LookupKey("renamed", "Username") != nil {
+		fmt.Println("FAIL: TestTableSnapshot update seen", row, v, ok)
+		t.Fail()
+	}
+	if snap.LookupKey(2, "Id") == nil || snap.LookupKey(101, "Id") != nil {
+		fmt.Println("FAIL: TestTableSnapshot delete or insert seen")
+		t.Fail()
+	}
+	if len(snap.LookupAll("Country", "US")) != 50 || len(snap.LookupAll("Country", "AU")) != 0 {
+		fmt.Println("FAIL: TestTableSnapshot non unique index")
+		t.Fail()
+	}
+	if top, _ := snap.RangeByRank("Score", -1, -1); len(top) != 1 || top[0].(snapTestObj).Score != 9 {
+		fmt.Println("FAIL: TestTableSnapshot sorted index", top)
+		t.Fail()
+	}
+	if rank, ok := snap.Rank("Score", 1); !ok || rank != 10 {
+		fmt.Println("FAIL: TestTableSnapshot rank", rank, ok)
+		t.Fail()
+	}
+	rows, err := snap.Range("Created", nil, nil, sc.RangeOptions{Limit: 3})
+	if err != nil || len(rows) != 3 || rows[0].(snapTestObj).Id != 1 || rows[1].(snapTestObj).Id != 2 {
+		fmt.Println("FAIL: TestTableSnapshot ordered index", rows, err)
+		t.Fail()
+	}
+	var ids []int
+	snap.Scan(func(row interface{}) bool {
+		ids = append(ids, row.(snapTestObj).Id)
+		return len(ids) < 5
+	})
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		fmt.Println("FAIL: TestTableSnapshot scan", ids)
+		t.Fail()
+	}
+
+	snap.Release()
+	snap.Release()
+	if _, err := snap.RangeByScore("Score", 0, 10); !errors.Is(err, sc.ErrReleased) || snap.LookupKey(3, "Id") != nil {
+		fmt.Println("FAIL: TestTableSnapshot after release", err)
+		t.Fail()
+	}
+	if err := snap.Scan(func(row interface{}) bool { return true }); !errors.Is(err, sc.ErrReleased) {
+		fmt.Println("FAIL: TestTableSnapshot scan after release", err)
+		t.Fail()
+	}
+}
+
+// Rows which had expired are left out, the rest stay for as long as the snapshot does
+func TestSnapshotExpiry(t *testing.T) {
+	db := sc.InitDb("testdb")
+	defer db.Close()
+	table, _ := db.AddTable("users", "Id")
+	table.InsertDataWithTTL(time.Nanosecond, snapTestObj{Id: 1})
+	table.InsertDataWithTTL(50 * time.Millisecond, snapTestObj{Id: 2})
+	time.Sleep(time.Millisecond)
+	snap := table.Snapshot()
+	defer snap.Release()
+	time.Sleep(60 * time.Millisecond)
+	if snap.Len() != 1 || snap.LookupKey(1, "Id") != nil || snap.LookupKey(2, "Id") == nil || table.LookupKey(2, "Id") != nil {
+		fmt.Println("FAIL: TestSnapshotExpiry", snap.Len())
+		t.Fail()
+	}
+}
+
+// A scan of a snapshot sees every row exactly once while writers carry on
+func TestSnapshotWhileWriting(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table := snapTestTable(db)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			id := i % 100 + 1
+			db.Update(func(tx *sc.Tx) error {
+				u, _ := tx.Table("users")
+				u.Delete("Id", id)
+				return u.InsertData(snapTestObj{Id: id, Username: fmt.Sprint("user", id), Score: i})
+			})
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		snap := table.Snapshot()
+		seen := make(map[int]bool)
+		snap.Scan(func(row interface{}) bool {
+			seen[row.(snapTestObj).Id] = true
+			return true
+		})
+		ranked, _ := snap.RangeByRank("Score", 0, -1)
+		if len(seen) != 100 || snap.Len() != 100 || len(ranked) != 100 {
+			fmt.Println("FAIL: TestSnapshotWhileWriting torn scan", len(seen), snap.Len(), len(ranked))
+			t.Fail()
+		}
+		snap.Release()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestDatabaseSnapshot(t *testing.T) {
+	db := sc.InitDb("testdb")
+	snapTestTable(db)
+	audit, _ := db.AddTable("audit", "Id")
+	snap := db.Snapshot()
+	defer snap.Release()
+	db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		a, _ := tx.Table("audit")
+		u.Delete("Id", 1)
+		return a.InsertData(snapTestObj{Id: 1})
+	})
+	users, _ := snap.Table("users")
+	audits, ok := snap.Table("audit")
+	if !ok || users.LookupKey(1, "Id") == nil || audits.Len() != 0 || sc.GetTableSize(audit) != 1 {
+		fmt.Println("FAIL: TestDatabaseSnapshot")
+		t.Fail()
+	}
+	if _, ok := snap.Table("nope"); ok {
+		fmt.Println("FAIL: TestDatabaseSnapshot missing table")
+		t.Fail()
+	}
+}
+
+func snapIds(rows []interface{}) []int {
+	ids := make([]int, len(rows))
+	for i, row := range rows {
+		ids[i] = row.(snapTestObj).Id
+	}
+	return ids
+}
+
+// Everything a snapshot can be asked about, to compare against what the table said at the time
+func snapState(tbl interface{}) string {
+	type reader interface {
+		LookupKey(key interface{}, idx string) interface{}
+		LookupAll(idx string, key interface{}) []interface{}
+		Rank(index string, key interface{}) (int, bool)
+		Score(index string, key interface{}) (float64, bool)
+		RangeByRank(index string, start, stop int) ([]interface{}, error)
+		RangeByScore(index string, min, max float64) ([]interface{}, error)
+		Range(index string, lo, hi interface{}, opts sc.RangeOptions) ([]interface{}, error)
+		Dump(w io.Writer, format sc.DumpFormat) error
+	}
+	r := tbl.(reader)
+	var out bytes.Buffer
+	for id := 1; id <= 120; id++ {
+		rank, ok := r.Rank("Score", id)
+		score, _ := r.Score("Score", id)
+		fmt.Fprintln(&out, id, r.LookupKey(id, "Id"), r.LookupKey(fmt.Sprint("user", id), "Username"), rank, ok, score)
+	}
+	fmt.Fprintln(&out, len(r.LookupAll("Country", "NZ")), len(r.LookupAll("Country", "US")), len(r.LookupAll("Country", "AU")))
+	for _, span := range [][2]int{{0, -1}, {5, 9}, {-3, -1}, {90, 200}} {
+		rows, _ := r.RangeByRank("Score", span[0], span[1])
+		fmt.Fprintln(&out, snapIds(rows))
+	}
+	rows, _ := r.RangeByScore("Score", 3, 5)
+	fmt.Fprintln(&out, snapIds(rows))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows, _ = r.Range("Created", nil, nil, sc.RangeOptions{})
+	fmt.Fprintln(&out, snapIds(rows))
+	rows, _ = r.Range("Created", start.Add(10 * time.Hour), start.Add(40 * time.Hour), sc.RangeOptions{Desc: true, Offset: 2, Limit: 7})
+	fmt.Fprintln(&out, snapIds(rows))
+	r.Dump(&out, sc.DumpText)
+	r.Dump(&out, sc.DumpJSON)
+	return out.String()
+}
+
+// A snapshot answers every kind of read the same as the table did when it was taken, however the rows are written
+// afterwards
+func TestSnapshotVersions(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table := snapTestTable(db)
+	// some rows with the same score in a different order to their IDs
+	for i := 1; i <= 100; i += 7 {
+		table.UpdateData(snapTestObj{i, fmt.Sprint("user", i), "NZ", 3, time.Date(2020, 1, 1, i % 5, 0, 0, 0, time.UTC)})
+	}
+	want := snapState(table)
+	snap := table.Snapshot()
+	defer snap.Release()
+
+	rng := rand.New(rand.NewSource(1))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 600; i++ {
+		id := rng.Intn(120) + 1
+		row := snapTestObj{id, fmt.Sprint("user", rng.Intn(120) + 1), []string{"NZ", "US", "AU"}[rng.Intn(3)], rng.Intn(10), start.Add(time.Duration(rng.Intn(200)) * time.Hour)}
+		switch rng.Intn(5) {
+		case 0:
+			table.Delete("Id", id)
+		case 1:
+			table.Patch("Id", id, map[string]interface{}{"Country": row.Country})
+		case 2:
+			db.Update(func(tx *sc.Tx) error {
+				u, _ := tx.Table("users")
+				u.SetData(row)
+				return errors.New("rolled back")
+			})
+		default:
+			// takes the username off whichever row has it
+			table.SetData(row)
+		}
+		if i % 100 == 0 {
+			if got := snapState(snap); got != want {
+				fmt.Println("FAIL: TestSnapshotVersions after", i, "writes")
+				t.FailNow()
+			}
+		}
+	}
+	if got := snapState(snap); got != want {
+		fmt.Println("FAIL: TestSnapshotVersions\n", got, "\nwanted\n", want)
+		t.Fail()
+	}
+	// a second snapshot sees the table as it is now, and the first is unchanged
+	want2 := snapState(table)
+	snap2 := table.Snapshot()
+	defer snap2.Release()
+	for id := 1; id <= 120; id++ {
+		table.Delete("Id", id)
+	}
+	if snapState(snap2) != want2 || snapState(snap) != want {
+		fmt.Println("FAIL: TestSnapshotVersions second snapshot")
+		t.Fail()
+	}
+}
+
+// Old versions are only kept while a snapshot needs them
+func TestSnapshotReleaseForgets(t *testing.T) {
+	table := snapTestTable(sc.InitDb("testdb"))
+	table.UpdateData(snapTestObj{Id: 1, Username: "a"})
+	if sc.SnapshotHistory(table) != 0 {
+		fmt.Println("FAIL: TestSnapshotReleaseForgets kept without a snapshot", sc.SnapshotHistory(table))
+		t.Fail()
+	}
+	older := table.Snapshot()
+	table.UpdateData(snapTestObj{Id: 1, Username: "b"})
+	table.UpdateData(snapTestObj{Id: 1, Username: "c"})
+	table.Delete("Id", 2)
+	newer := table.Snapshot()
+	table.UpdateData(snapTestObj{Id: 1, Username: "d"})
+	table.InsertData(snapTestObj{Id: 200, Username: "new"})
+	if n := sc.SnapshotHistory(table); n != 4 {
+		fmt.Println("FAIL: TestSnapshotReleaseForgets one version per row per snapshot", n)
+		t.Fail()
+	}
+	older.Release()
+	if n := sc.SnapshotHistory(table); n != 2 || newer.LookupKey(1, "Id").(snapTestObj).Username != "c" {
+		fmt.Println("FAIL: TestSnapshotReleaseForgets older released", n)
+		t.Fail()
+	}
+	newer.Release()
+	if n := sc.SnapshotHistory(table); n != 0 {
+		fmt.Println("FAIL: TestSnapshotReleaseForgets all released", n)
+		t.Fail()
+	}
+	table.UpdateData(snapTestObj{Id: 1, Username: "e"})
+	if n := sc.SnapshotHistory(table); n != 0 {
+		fmt.Println("FAIL: TestSnapshotReleaseForgets kept after release", n)
+		t.Fail()
+	}
+}
+
+// Dropping an index or cleaning the table doesn't take anything away from a snapshot
+func TestSnapshotDropAndClean(t *testing.T) {
+	table := snapTestTable(sc.InitDb("testdb"))
+	snap := table.Snapshot()
+	defer snap.Release()
+	table.DropIndex("Country")
+	table.UpdateData(snapTestObj{Id: 1, Username: "user1", Country: "AU"})
+	table.DropIndex("Score")
+	table.AddIndex("Score", sc.IndexOptions{})
+	table.CleanTableData()
+	table.InsertData(snapTestObj{Id: 1, Username: "user1", Country: "AU", Score: 50})
+
+	if len(snap.LookupAll("Country", "US")) != 50 || len(snap.LookupAll("Country", "AU")) != 0 {
+		fmt.Println("FAIL: TestSnapshotDropAndClean dropped index", len(snap.LookupAll("Country", "US")))
+		t.Fail()
+	}
+	ranked, err := snap.RangeByRank("Score", 0, -1)
+	rank, ok := snap.Rank("Score", 1)
+	if err != nil || len(ranked) != 100 || !ok || rank != 10 {
+		fmt.Println("FAIL: TestSnapshotDropAndClean sorted index", len(ranked), err, rank, ok)
+		t.Fail()
+	}
+	count := 0
+	snap.Scan(func(row interface{}) bool {
+		count++
+		return true
+	})
+	if snap.Len() != 100 || count != 100 || snap.LookupKey(1, "Id").(snapTestObj).Country != "US" || sc.GetTableSize(table) != 1 {
+		fmt.Println("FAIL: TestSnapshotDropAndClean cleaned", snap.Len(), count)
+		t.Fail()
+	}
+}
@@ -0,0 +1,237 @@
+package sc
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Position of a row in a sorted index, 0 being the lowest score. Rows with the same score are ranked in the order
+// they were written. key is the row's primary key. ok is false if there's no such row or it has expired.
+// Expired rows which haven't been removed yet still take up a rank. O(log n)
+func (tbl *Table) Rank(index string, key interface{}) (rank int, ok bool) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.rank(index, key)
+}
+
+// Does the work of Rank. Caller must hold the table lock
+func (tbl *Table) rank(index string, key interface{}) (rank int, ok bool) {
+	sl, err := tbl.skipListOf(index, SortedIndex)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := tbl.nodeOf(sl, key)
+	if !ok {
+		return 0, false
+	}
+	return sl.rank(n.rec.id)
+}
+
+// Score of a row in a sorted index, ie. its field value as a float64. key is the row's primary key.
+// ok is false if there's no such row or it has expired. O(1)
+func (tbl *Table) Score(index string, key interface{}) (score float64, ok bool) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.score(index, key)
+}
+
+// Does the work of Score. Caller must hold the table lock
+func (tbl *Table) score(index string, key interface{}) (score float64, ok bool) {
+	sl, err := tbl.skipListOf(index, SortedIndex)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := tbl.nodeOf(sl, key)
+	if !ok {
+		return 0, false
+	}
+	return n.key.(float64), true
+}
+
+// Rows ranked start to stop inclusive in a sorted index, lowest score first. Like Redis ZRANGE negative positions
+// count back from the end so RangeByRank(index, 0, -1) is every row and (index, -3, -1) is the top 3.
+// O(log n + m) for m rows returned
+func (tbl *Table) RangeByRank(index string, start, stop int) ([]interface{}, error) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.rangeByRank(index, start, stop)
+}
+
+// Does the work of RangeByRank. Caller must hold the table lock
+func (tbl *Table) rangeByRank(index string, start, stop int) ([]interface{}, error) {
+	sl, err := tbl.skipListOf(index, SortedIndex)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start += sl.length
+	}
+	if stop < 0 {
+		stop += sl.length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= sl.length {
+		stop = sl.length - 1
+	}
+	var recs []*record
+	n := sl.byRank(start)
+	for i := start; i <= stop && n != nil; i++ {
+		recs = append(recs, n.rec)
+		n = n.levels[0].next
+	}
+	return tbl.results(recs), nil
+}
+
+// Rows with a score between min and max inclusive in a sorted index, lowest score first. O(log n + m) for m rows
+// returned
+func (tbl *Table) RangeByScore(index string, min, max float64) ([]interface{}, error) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	return tbl.rangeByScore(index, min, max)
+}
+
+// Does the work of RangeByScore. Caller must hold the table lock
+func (tbl *Table) rangeByScore(index string, min, max float64) ([]interface{}, error) {
+	sl, err := tbl.skipListOf(index, SortedIndex)
+	if err != nil {
+		return nil, err
+	}
+	var recs []*record
+	for n := sl.firstFrom(min); n != nil && n.key.(float64) <= max; n = n.levels[0].next {
+		recs = append(recs, n.rec)
+	}
+	return tbl.results(recs), nil
+}
+
+// The skip list node of the unexpired row with primary key pk. Caller must hold the table lock
+func (tbl *Table) nodeOf(sl *skipList, pk interface{}) (*skipNode, bool) {
+	pk = tbl.Indexes[tbl.pk].normalize(pk)
+	if !hashable(pk) {
+		return nil, false
+	}
+	rec, ok := tbl.Indexes[tbl.pk].get(pk)
+	if !ok || tbl.expired(rec, time.Now()) {
+		return nil, false
+	}
+	n, ok := sl.nodes[rec.id]
+	return n, ok
+}
+
+// The skip list behind a sorted or ordered index, making sure the index is of the given kind.
+// Caller must hold the table lock
+func (tbl *Table) skipListOf(index string, kind IndexKind) (*skipList, error) {
+	return skipListIn(tbl.Indexes, tbl.Name, index, kind)
+}
+
+// Same as skipListOf for a table's indexes as they were at some point, see TableSnapshot
+func skipListIn(indexes map[string]Index, table, index string, kind IndexKind) (*skipList, error) {
+	idx, ok := indexes[index]
+	if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "Index %s does not exist in table %s", index, table)
+	}
+	if idx.Kind != kind {
+		if kind == SortedIndex {
+			return nil, errors.Errorf("Index %s is not a sorted index", index)
+		}
+		return nil, errors.Errorf("Index %s is not an ordered index", index)
+	}
+	return idx.sorted, nil
+}
+
+// Whether key can be stored in this index. For a multi value index key is the whole slice
+func (idx Index) checkKey(name string, key interface{}) error {
+	if idx.Multi {
+		if kind := reflect.ValueOf(key).Kind(); kind != reflect.Slice && kind != reflect.Array {
+			return fmt.Errorf("Multi value index %s needs a slice or array but got %v", name, key)
+		}
+		return nil
+	}
+	sortKey, ok := idx.sortKey(key)
+	if !ok {
+		switch idx.Kind {
+		case SortedIndex:
+			return fmt.Errorf("Sorted index %s needs a number but got %v", name, key)
+		case OrderedIndex:
+			return fmt.Errorf("Ordered index %s needs a number, string or time.Time but got %v", name, key)
+		}
+	}
+	// keys of different sorts can't be compared, so the first one in decides what the rest must be
+	if idx.Kind == OrderedIndex {
+		if first := idx.sorted.head.levels[0].next; first != nil && orderClass(first.key) != orderClass(sortKey) {
+			return fmt.Errorf("Key %v is a different type to the keys in ordered index %s", key, name)
+		}
+	}
+	return nil
+}
+
+// Make sure the rows in a batch agree on the sort of key they have in each ordered index. checkKey only compares a
+// row with the keys already in the index, which doesn't include rows earlier in the same batch.
+// Caller must hold the table lock
+func (tbl *Table) checkBatchKeys(data []interface{}) error {
+	if len(data) < 2 {
+		return nil
+	}
+	for name, idx := range tbl.Indexes {
+		if idx.Kind != OrderedIndex {
+			continue
+		}
+		class := -1
+		for _, d := range data {
+			key, ok := idx.keyOf(fieldsOf(d))
+			if !ok {
+				continue
+			}
+			sortKey, _ := idx.sortKey(key)
+			if class == -1 {
+				class = orderClass(sortKey)
+			} else if orderClass(sortKey) != class {
+				return fmt.Errorf("Key %v is a different type to other keys for ordered index %s in the batch", key, name)
+			}
+		}
+	}
+	return nil
+}
+
+// The key a row is ordered by in the skip list of a sorted or ordered index. ok is false if key can't be ordered,
+// and is always true for hash indexes which don't need one
+func (idx Index) sortKey(key interface{}) (interface{}, bool) {
+	switch idx.Kind {
+	case SortedIndex:
+		return toScore(key)
+	case OrderedIndex:
+		return orderedKey(key)
+	}
+	return nil, true
+}
+
+// Any int, uint or float as a float64. NaN is rejected since it can't be ordered
+func toScore(v interface{}) (float64, bool) {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(val.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		return f, !math.IsNaN(f)
+	}
+	return 0, false
+}
+
+func compareScores(a, b interface{}) int {
+	x, y := a.(float64), b.(float64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
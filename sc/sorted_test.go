@@ -0,0 +1,176 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+type member struct {
+	Name string
+	Score float64
+}
+
+func newLeaderboard(t *testing.T) *sc.Table {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{
+		PrimaryKey: "Name",
+		Indexes: map[string]sc.IndexOptions{"Score": {Kind: sc.SortedIndex}},
+	}
+	table, err := db.AddTableWithOptions("leaderboard", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func names(rows []interface{}) []string {
+	result := make([]string, len(rows))
+	for i, row := range rows {
+		result[i] = row.(member).Name
+	}
+	return result
+}
+
+func TestSortedIndex(t *testing.T) {
+	table := newLeaderboard(t)
+	table.InsertData(member{"a", 30}, member{"b", 10}, member{"c", 20}, member{"d", 20}, member{"e", 50})
+
+	rows, err := table.RangeByRank("Score", 0, -1)
+	if err != nil || fmt.Sprint(names(rows)) != "[b c d a e]" {
+		fmt.Println("FAIL: TestSortedIndex RangeByRank all", names(rows), err)
+		t.Fail()
+	}
+	// top 2
+	rows, _ = table.RangeByRank("Score", -2, -1)
+	if fmt.Sprint(names(rows)) != "[a e]" {
+		fmt.Println("FAIL: TestSortedIndex RangeByRank top", names(rows))
+		t.Fail()
+	}
+	rows, _ = table.RangeByRank("Score", 3, 100)
+	if fmt.Sprint(names(rows)) != "[a e]" {
+		fmt.Println("FAIL: TestSortedIndex RangeByRank past the end", names(rows))
+		t.Fail()
+	}
+	rows, _ = table.RangeByScore("Score", 15, 30)
+	if fmt.Sprint(names(rows)) != "[c d a]" {
+		fmt.Println("FAIL: TestSortedIndex RangeByScore", names(rows))
+		t.Fail()
+	}
+	if rank, ok := table.Rank("Score", "d"); !ok || rank != 2 {
+		fmt.Println("FAIL: TestSortedIndex Rank", rank, ok)
+		t.Fail()
+	}
+	if score, ok := table.Score("Score", "e"); !ok || score != 50 {
+		fmt.Println("FAIL: TestSortedIndex Score", score, ok)
+		t.Fail()
+	}
+	// exact scores can still be looked up like any other non unique index
+	if len(table.LookupAll("Score", 20.0)) != 2 {
+		fmt.Println("FAIL: TestSortedIndex LookupAll")
+		t.Fail()
+	}
+
+	// changing a score moves the row, deleting it takes it out
+	table.SetData(member{"b", 60})
+	table.Delete("Name", "a")
+	rows, _ = table.RangeByRank("Score", 0, -1)
+	if fmt.Sprint(names(rows)) != "[c d e b]" {
+		fmt.Println("FAIL: TestSortedIndex after update", names(rows))
+		t.Fail()
+	}
+	if _, ok := table.Rank("Score", "a"); ok {
+		fmt.Println("FAIL: TestSortedIndex deleted row still ranked")
+		t.Fail()
+	}
+
+	// wrong kind of index or field
+	if _, err = table.RangeByRank("Name", 0, -1); err == nil {
+		fmt.Println("FAIL: TestSortedIndex range over hash index")
+		t.Fail()
+	}
+	type badMember struct {
+		Name string
+		Score string
+	}
+	if err = table.InsertData(badMember{"f", "high"}); err == nil || sc.GetTableSize(table) != 4 {
+		fmt.Println("FAIL: TestSortedIndex accepted a non numeric score")
+		t.Fail()
+	}
+	if _, err = sc.InitDb("testdb").AddTableWithOptions("t", sc.TableOptions{PrimaryKey: "Name",
+		Indexes: map[string]sc.IndexOptions{"Name": {Unique: true, Kind: sc.SortedIndex}}}); err == nil {
+		fmt.Println("FAIL: TestSortedIndex sorted primary key")
+		t.Fail()
+	}
+
+	table.CleanTableData()
+	if rows, _ = table.RangeByRank("Score", 0, -1); len(rows) != 0 {
+		fmt.Println("FAIL: TestSortedIndex CleanTableData", names(rows))
+		t.Fail()
+	}
+}
+
+// Random writes and deletes should leave the ranks matching a plain sort of the rows
+func TestSortedIndexRandom(t *testing.T) {
+	table := newLeaderboard(t)
+	rnd := rand.New(rand.NewSource(1))
+	scores := make(map[string]float64)
+	for i := 0; i < 2000; i++ {
+		name := fmt.Sprintf("m%d", rnd.Intn(300))
+		if rnd.Intn(4) == 0 {
+			table.Delete("Name", name)
+			delete(scores, name)
+			continue
+		}
+		score := float64(rnd.Intn(100))
+		table.SetData(member{name, score})
+		scores[name] = score
+	}
+
+	rows, _ := table.RangeByRank("Score", 0, -1)
+	if len(rows) != len(scores) {
+		fmt.Println("FAIL: TestSortedIndexRandom size", len(rows), len(scores))
+		t.FailNow()
+	}
+	expected := make([]float64, 0, len(scores))
+	for _, s := range scores {
+		expected = append(expected, s)
+	}
+	sort.Float64s(expected)
+	for i, row := range rows {
+		m := row.(member)
+		rank, _ := table.Rank("Score", m.Name)
+		if m.Score != expected[i] || m.Score != scores[m.Name] || rank != i {
+			fmt.Println("FAIL: TestSortedIndexRandom at", i, m, rank)
+			t.FailNow()
+		}
+	}
+	inRange, _ := table.RangeByScore("Score", 25, 74)
+	count := 0
+	for _, s := range scores {
+		if s >= 25 && s <= 74 {
+			count++
+		}
+	}
+	if len(inRange) != count {
+		fmt.Println("FAIL: TestSortedIndexRandom RangeByScore", len(inRange), count)
+		t.Fail()
+	}
+}
+
+func TestAddSortedIndex(t *testing.T) {
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTable("leaderboard", "Name")
+	table.InsertData(member{"a", 3}, member{"b", 1}, member{"c", 2})
+	if err := table.AddIndex("Score", sc.IndexOptions{Kind: sc.SortedIndex}); err != nil {
+		fmt.Println("FAIL: TestAddSortedIndex", err)
+		t.FailNow()
+	}
+	rows, _ := table.RangeByRank("Score", 0, -1)
+	if fmt.Sprint(names(rows)) != "[b c a]" {
+		fmt.Println("FAIL: TestAddSortedIndex order", names(rows))
+		t.Fail()
+	}
+}
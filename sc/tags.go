@@ -0,0 +1,203 @@
+package sc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Create a table of T rows with the indexes declared by sc struct tags on T's fields, eg.
+//
+//	type User struct {
+//		Id string `sc:"pk"`
+//		Email string `sc:"unique,lower"`
+//		Score int `sc:"sorted"`
+//		Tags []string `sc:"multi"`
+//	}
+//	users, err := sc.AddTableFor[User](db, "users")
+//
+// See TableOptionsFor for what the tags mean
+func AddTableFor[T any](db *Database, tableName string) (*TypedTable[T], error) {
+	opts, err := TableOptionsFor[T]()
+	if err != nil {
+		return nil, err
+	}
+	return NewTableWithOptions[T](db, tableName, opts)
+}
+
+// Work out a table's primary key and indexes from the sc struct tags on T's fields. Handy for adding a TTL or size
+// limit before calling NewTableWithOptions. A tag is a comma separated list of:
+//
+//	pk       the primary key, exactly one field or compound group needs this
+//	index    plain non unique hash index, which is what a field gets if it only has lower or name=
+//	unique   unique hash index
+//	lower    case insensitive, the field must be a string or for multi a slice of strings
+//	sorted   SortedIndex, the field must be a number
+//	ordered  OrderedIndex, the field must be a number, string or time.Time
+//	multi    index a slice field by each element
+//	name=X   call the index X instead of the field name. Fields sharing a name make a compound index in field order
+//
+// Tags on fields promoted from embedded structs count too. Untagged fields and fields tagged "-" aren't indexed.
+// Conflicting tags, eg. unique and multi together, or fields of the wrong type are an error
+func TableOptionsFor[T any]() (TableOptions, error) {
+	return tagOptions(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// Does the work of TableOptionsFor
+func tagOptions(typ reflect.Type) (TableOptions, error) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	opts := TableOptions{Indexes: make(map[string]IndexOptions)}
+	if typ.Kind() != reflect.Struct {
+		return opts, fmt.Errorf("Row type %s is not a struct", typ)
+	}
+	// whether each index is the primary key, to check every field of a compound key agrees
+	pks := make(map[string]bool)
+	for _, field := range taggedFields(typ, 0) {
+		tag := field.Tag.Get("sc")
+		if !field.IsExported() {
+			return opts, fmt.Errorf("Field %s of %s has an sc tag but isn't exported", field.Name, typ)
+		}
+		name, idxOpts, pk, err := parseTag(field, tag)
+		if err != nil {
+			return opts, fmt.Errorf("Field %s of %s: %s", field.Name, typ, err)
+		}
+
+		existing, ok := opts.Indexes[name]
+		if !ok {
+			opts.Indexes[name] = idxOpts
+			pks[name] = pk
+		} else {
+			// another field of a compound index, which must be tagged the same way
+			fields := existing.Fields
+			existing.Fields, idxOpts.Fields = nil, nil
+			if !reflect.DeepEqual(existing, idxOpts) || pks[name] != pk {
+				return opts, fmt.Errorf("Field %s of %s is tagged differently to the other fields of index %s", field.Name, typ, name)
+			}
+			existing.Fields = append(fields, field.Name)
+			opts.Indexes[name] = existing
+		}
+		if pk {
+			if opts.PrimaryKey != "" && opts.PrimaryKey != name {
+				return opts, fmt.Errorf("Both %s and %s of %s are tagged pk", opts.PrimaryKey, name, typ)
+			}
+			opts.PrimaryKey = name
+		}
+	}
+	if opts.PrimaryKey == "" {
+		return opts, fmt.Errorf("No field of %s is tagged pk", typ)
+	}
+	for name, idxOpts := range opts.Indexes {
+		if err := checkIndexOptions(name, idxOpts, name == opts.PrimaryKey); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// Every field with an sc tag, including ones promoted from embedded structs which aren't tagged themselves.
+// depth stops a struct which embeds a pointer to itself from going round forever
+func taggedFields(typ reflect.Type, depth int) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup("sc")
+		if ok && tag != "-" {
+			fields = append(fields, field)
+			continue
+		}
+		embedded := field.Type
+		for embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if !ok && field.Anonymous && embedded.Kind() == reflect.Struct && depth < maxEmbedDepth {
+			fields = append(fields, taggedFields(embedded, depth + 1)...)
+		}
+	}
+	return fields
+}
+
+// How deep taggedFields looks into embedded structs
+const maxEmbedDepth = 8
+
+// The index a field's tag declares, with Fields set to just that field
+func parseTag(field reflect.StructField, tag string) (name string, opts IndexOptions, pk bool, err error) {
+	name = field.Name
+	opts.Fields = []string{field.Name}
+	kinds := 0
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "pk":
+			pk = true
+			opts.Unique = true
+		case part == "index":
+		case part == "unique":
+			opts.Unique = true
+		case part == "lower":
+			opts.Lower = true
+		case part == "sorted":
+			opts.Kind = SortedIndex
+			kinds++
+		case part == "ordered":
+			opts.Kind = OrderedIndex
+			kinds++
+		case part == "multi":
+			opts.Multi = true
+		case strings.HasPrefix(part, "name="):
+			name = strings.TrimPrefix(part, "name=")
+			if name == "" {
+				return name, opts, pk, fmt.Errorf("empty index name")
+			}
+		default:
+			return name, opts, pk, fmt.Errorf("unknown sc tag option %q", part)
+		}
+	}
+
+	switch {
+	case kinds > 1:
+		return name, opts, pk, fmt.Errorf("can't be both sorted and ordered")
+	case pk && (opts.Kind != HashIndex || opts.Multi):
+		return name, opts, pk, fmt.Errorf("primary key can't be sorted, ordered or multi")
+	case opts.Multi && opts.Unique:
+		return name, opts, pk, fmt.Errorf("multi index can't be unique")
+	case opts.Multi && opts.Kind != HashIndex:
+		return name, opts, pk, fmt.Errorf("multi index can't be sorted or ordered")
+	case opts.Lower && opts.Kind == SortedIndex:
+		return name, opts, pk, fmt.Errorf("sorted index is numeric so can't be lower")
+	}
+
+	typ := field.Type
+	if opts.Multi {
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array {
+			return name, opts, pk, fmt.Errorf("multi index needs a slice or array, not %s", typ)
+		}
+		typ = typ.Elem()
+	}
+	if opts.Lower && typ.Kind() != reflect.String {
+		return name, opts, pk, fmt.Errorf("lower needs a string, not %s", typ)
+	}
+	switch opts.Kind {
+	case SortedIndex:
+		if !isNumber(typ) {
+			return name, opts, pk, fmt.Errorf("sorted index needs a number, not %s", typ)
+		}
+	case OrderedIndex:
+		if !isNumber(typ) && typ.Kind() != reflect.String && typ != reflect.TypeOf(time.Time{}) {
+			return name, opts, pk, fmt.Errorf("ordered index needs a number, string or time.Time, not %s", typ)
+		}
+	}
+	return name, opts, pk, nil
+}
+
+func isNumber(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
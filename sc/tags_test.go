@@ -0,0 +1,133 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+)
+
+type taggedUser struct {
+	Id string `sc:"pk"`
+	Email string `sc:"unique,lower"`
+	Score int `sc:"sorted"`
+	Tags []string `sc:"multi"`
+	Country string `sc:"name=Country_City"`
+	City string `sc:"name=Country_City"`
+	Bio string
+}
+
+func TestAddTableFor(t *testing.T) {
+	db := sc.InitDb("testdb")
+	users, err := sc.AddTableFor[taggedUser](db, "users")
+	if err != nil {
+		fmt.Println("FAIL: TestAddTableFor", err)
+		t.FailNow()
+	}
+	if len(users.Table().ListIndexNames()) != 5 {
+		fmt.Println("FAIL: TestAddTableFor indexes", users.Table().ListIndexNames())
+		t.Fail()
+	}
+
+	alice := taggedUser{Id: "1", Email: "Alice@Example.com", Score: 5, Tags: []string{"go", "db"}, Country: "NZ", City: "Auckland"}
+	bob := taggedUser{Id: "2", Email: "bob@example.com", Score: 3, Tags: []string{"go"}, Country: "NZ", City: "Auckland"}
+	if err = users.Insert(alice, bob); err != nil {
+		fmt.Println("FAIL: TestAddTableFor insert", err)
+		t.FailNow()
+	}
+	// lower makes the email case insensitive, for lookups and uniqueness
+	if row, ok := users.Get("Email", "ALICE@example.com"); !ok || row.Id != "1" {
+		fmt.Println("FAIL: TestAddTableFor lower lookup")
+		t.Fail()
+	}
+	if err = users.Insert(taggedUser{Id: "3", Email: "BOB@example.com"}); err == nil {
+		fmt.Println("FAIL: TestAddTableFor lower uniqueness")
+		t.Fail()
+	}
+	if len(users.GetAll("Tags", "go")) != 2 || len(users.GetAll("Tags", "db")) != 1 {
+		fmt.Println("FAIL: TestAddTableFor multi")
+		t.Fail()
+	}
+	if rank, ok := users.Table().Rank("Score", "1"); !ok || rank != 1 {
+		fmt.Println("FAIL: TestAddTableFor sorted", rank, ok)
+		t.Fail()
+	}
+	if len(users.GetAll("Country_City", sc.Key("NZ", "Auckland"))) != 2 {
+		fmt.Println("FAIL: TestAddTableFor compound")
+		t.Fail()
+	}
+
+	// changing the tags takes the row out from under the ones it lost
+	alice.Tags = []string{"db"}
+	users.Upsert(alice)
+	if len(users.GetAll("Tags", "go")) != 1 || len(users.GetAll("Tags", "db")) != 1 {
+		fmt.Println("FAIL: TestAddTableFor multi after update")
+		t.Fail()
+	}
+	users.Delete("Id", "1")
+	if len(users.GetAll("Tags", "db")) != 0 {
+		fmt.Println("FAIL: TestAddTableFor multi after delete")
+		t.Fail()
+	}
+}
+
+type noPk struct {
+	Id string `sc:"unique"`
+}
+type twoPks struct {
+	Id string `sc:"pk"`
+	Other string `sc:"pk"`
+}
+type uniqueMulti struct {
+	Id string `sc:"pk"`
+	Tags []string `sc:"multi,unique"`
+}
+type sortedString struct {
+	Id string `sc:"pk"`
+	Name string `sc:"sorted"`
+}
+type lowerInt struct {
+	Id string `sc:"pk"`
+	Age int `sc:"lower"`
+}
+type multiNotSlice struct {
+	Id string `sc:"pk"`
+	Tag string `sc:"multi"`
+}
+type unknownOption struct {
+	Id string `sc:"pk,primary"`
+}
+type compoundMismatch struct {
+	Id string `sc:"pk"`
+	Country string `sc:"name=Place,unique"`
+	City string `sc:"name=Place"`
+}
+type sortedAndOrdered struct {
+	Id string `sc:"pk"`
+	Score int `sc:"sorted,ordered"`
+}
+
+func TestTableOptionsForConflicts(t *testing.T) {
+	checks := map[string]func() (sc.TableOptions, error){
+		"no pk": sc.TableOptionsFor[noPk],
+		"two pks": sc.TableOptionsFor[twoPks],
+		"unique multi": sc.TableOptionsFor[uniqueMulti],
+		"sorted string": sc.TableOptionsFor[sortedString],
+		"lower int": sc.TableOptionsFor[lowerInt],
+		"multi not slice": sc.TableOptionsFor[multiNotSlice],
+		"unknown option": sc.TableOptionsFor[unknownOption],
+		"compound mismatch": sc.TableOptionsFor[compoundMismatch],
+		"sorted and ordered": sc.TableOptionsFor[sortedAndOrdered],
+	}
+	for name, check := range checks {
+		if _, err := check(); err == nil {
+			fmt.Println("FAIL: TestTableOptionsForConflicts accepted", name)
+			t.Fail()
+		}
+	}
+
+	opts, err := sc.TableOptionsFor[taggedUser]()
+	if err != nil || opts.PrimaryKey != "Id" || fmt.Sprint(opts.Indexes["Country_City"].Fields) != "[Country City]" {
+		fmt.Println("FAIL: TestTableOptionsForConflicts valid type", opts, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,189 @@
+package sc
+
+import (
+	"container/heap"
+	"time"
+)
+
+// How often the janitor goroutine sweeps expired rows out of every table
+var janitorInterval = time.Second
+
+// Same as SetData but the rows expire after ttl instead of the table's default TTL
+func (tbl *Table) SetDataWithTTL(ttl time.Duration, data... interface{}) error {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commit(tbl.addData(ttl, data...))
+}
+
+// Same as InsertData but the rows expire after ttl instead of the table's default TTL
+func (tbl *Table) InsertDataWithTTL(ttl time.Duration, data... interface{}) error {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commit(tbl.insertData(ttl, data...))
+}
+
+// Stop the janitor goroutine which removes expired rows. Expired rows are still hidden from lookups afterwards, they
+// just aren't reclaimed until they are next written over or the table needs room. Safe to call more than once.
+// A db from Open also has its log flushed and closed, after which writes fail with ErrClosed
+func (db *Database) Close() error {
+	var err error
+	db.closeOnce.Do(func() {
+		close(db.stopJanitor)
+		// make sure a janitor can't start after this, and wait for one which already has
+		started := true
+		db.janitorOnce.Do(func() { started = false })
+		if started {
+			<-db.janitorDone
+		}
+		if db.wal != nil {
+			err = db.wal.close()
+		}
+	})
+	return err
+}
+
+// Start the janitor if it isn't already running
+func (db *Database) startJanitor() {
+	db.janitorOnce.Do(func() {
+		go db.janitor()
+	})
+}
+
+// Every janitorInterval remove expired rows from all the tables until the db is closed
+func (db *Database) janitor() {
+	defer close(db.janitorDone)
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stopJanitor:
+			return
+		case now := <-ticker.C:
+			db.mu.RLock()
+			tables := make([]*Table, 0, len(db.Tables))
+			for _, tbl := range db.Tables {
+				tables = append(tables, tbl)
+			}
+			db.mu.RUnlock()
+			for _, tbl := range tables {
+				tbl.RemoveExpired(now)
+			}
+		}
+	}
+}
+
+// Remove every row which expired at or before now from all indexes. Returns how many were removed.
+// The janitor calls this periodically, call it directly to reclaim memory sooner
+func (tbl *Table) RemoveExpired(now time.Time) int {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	n := tbl.removeExpired(now)
+	if tbl.commit(nil) != nil {
+		return 0
+	}
+	return n
+}
+
+// Does the work of RemoveExpired. Caller must hold the table write lock
+func (tbl *Table) removeExpired(now time.Time) int {
+	removed := 0
+	for len(tbl.expiryQueue) > 0 && !tbl.expiryQueue[0].at.After(now) {
+		item := heap.Pop(&tbl.expiryQueue).(expiryItem)
+		// the row may have been removed or given a new expiry since this was queued
+		if tbl.expiring[item.rec.id] != item.rec || !item.rec.expires.Equal(item.at) {
+			continue
+		}
+		tbl.evict(item.rec, ReasonExpired)
+		removed++
+	}
+	return removed
+}
+
+// Give a row a new expiry time ttl from now, or no expiry if ttl is 0. Caller must hold the table write lock
+func (tbl *Table) setExpiry(rec *record, ttl time.Duration) {
+	tbl.logUndo(undoExpiry, rec)
+	if ttl <= 0 {
+		rec.expires = time.Time{}
+		delete(tbl.expiring, rec.id)
+		return
+	}
+	rec.expires = time.Now().Add(ttl)
+	tbl.expiring[rec.id] = rec
+	heap.Push(&tbl.expiryQueue, expiryItem{at: rec.expires, rec: rec})
+	tbl.db.startJanitor()
+}
+
+// How long until a row expires, or 0 if it doesn't. Caller must hold the table lock
+func (tbl *Table) remainingTTL(rec *record) time.Duration {
+	if rec.expires.IsZero() {
+		return 0
+	}
+	return time.Until(rec.expires)
+}
+
+// Whether a row has expired as of now. Caller must hold the table lock
+func (tbl *Table) expired(rec *record, now time.Time) bool {
+	return !rec.expires.IsZero() && !now.Before(rec.expires)
+}
+
+// The rows of the records which haven't expired, each counted as used. Caller must hold the table lock
+func (tbl *Table) results(recs []*record) []interface{} {
+	now := time.Now()
+	rows := make([]interface{}, 0, len(recs))
+	for _, rec := range recs {
+		if !tbl.expired(rec, now) {
+			tbl.accessed(rec)
+			rows = append(rows, tbl.rowOut(rec))
+		}
+	}
+	return rows
+}
+
+// How many rows have expired but are still in the table. Caller must hold the table lock
+func (tbl *Table) countExpired(now time.Time) int {
+	count := 0
+	for _, rec := range tbl.expiring {
+		if tbl.expired(rec, now) {
+			count++
+		}
+	}
+	return count
+}
+
+// Get the unexpired record stored under key in a unique index, removing it if it has expired.
+// Caller must hold the table write lock
+func (tbl *Table) liveRecord(index string, key interface{}) (*record, bool) {
+	idx := tbl.Indexes[index]
+	key = idx.normalize(key)
+	if !hashable(key) {
+		return nil, false
+	}
+	rec, ok := idx.get(key)
+	if !ok {
+		return nil, false
+	}
+	if tbl.expired(rec, time.Now()) {
+		tbl.evict(rec, ReasonExpired)
+		return nil, false
+	}
+	return rec, true
+}
+
+type expiryItem struct {
+	at time.Time
+	rec *record
+}
+
+// Min heap of expiry times so the janitor only looks at rows which are actually due
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryItem)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old) - 1]
+	*h = old[:len(old) - 1]
+	return item
+}
@@ -0,0 +1,139 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"time"
+)
+
+type ttlTestObj struct {
+	Id string
+	Token string
+}
+
+func TestSetDataWithTTL(t *testing.T) {
+	db := sc.InitDb("testdb")
+	defer db.Close()
+	table, _ := db.AddTable("sessions", "Id", "Token")
+
+	short := ttlTestObj{Id: "Id1", Token: "tok1"}
+	long := ttlTestObj{Id: "Id2", Token: "tok2"}
+	forever := ttlTestObj{Id: "Id3", Token: "tok3"}
+	// checked with a long TTL so a slow machine can't get there after it runs out, then cut short
+	table.SetDataWithTTL(time.Hour, short)
+	table.SetDataWithTTL(time.Hour, long)
+	table.SetData(forever)
+	if sc.GetTableSize(table) != 3 || table.LookupKey("tok1", "Token") != short {
+		fmt.Println("FAIL: SetDataWithTTL before expiry")
+		t.Fail()
+	}
+
+	table.SetDataWithTTL(50 * time.Millisecond, short)
+	time.Sleep(60 * time.Millisecond)
+	// hidden straight away from every index even though the janitor hasn't been round yet
+	if table.LookupKey("Id1", "Id") != nil || table.LookupKey("tok1", "Token") != nil ||
+		len(table.LookupAll("Id", "Id1")) != 0 || sc.GetTableSize(table) != 2 {
+		fmt.Println("FAIL: SetDataWithTTL expired row still visible")
+		t.Fail()
+	}
+	if table.LookupKey("Id2", "Id") != long || table.LookupKey("Id3", "Id") != forever {
+		fmt.Println("FAIL: SetDataWithTTL unexpired rows gone")
+		t.Fail()
+	}
+
+	// expired keys don't block inserts and can't be updated or deleted
+	if err := table.UpdateData(short); err == nil {
+		fmt.Println("FAIL: UpdateData on expired row")
+		t.Fail()
+	}
+	if _, err := table.Delete("Id", "Id1"); err == nil {
+		fmt.Println("FAIL: Delete on expired row")
+		t.Fail()
+	}
+	if err := table.InsertDataWithTTL(time.Hour, short); err != nil || table.LookupKey("tok1", "Token") != short {
+		fmt.Println("FAIL: Insert over expired row", err)
+		t.Fail()
+	}
+
+	// SetData without a TTL clears it
+	table.SetDataWithTTL(50 * time.Millisecond, long)
+	table.SetData(long)
+	time.Sleep(60 * time.Millisecond)
+	if table.LookupKey("Id2", "Id") != long {
+		fmt.Println("FAIL: SetData didn't clear TTL")
+		t.Fail()
+	}
+
+	// UpdateData keeps the TTL it had
+	table.SetDataWithTTL(50 * time.Millisecond, forever)
+	table.UpdateData(forever)
+	time.Sleep(60 * time.Millisecond)
+	if table.LookupKey("Id3", "Id") != nil {
+		fmt.Println("FAIL: UpdateData lost TTL")
+		t.Fail()
+	}
+
+	// explicit sweep takes them out of the indexes
+	if removed := table.RemoveExpired(time.Now()); removed != 1 || len(table.Indexes["Token"].Idx) != 2 {
+		fmt.Println("FAIL: RemoveExpired", removed)
+		t.Fail()
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	db := sc.InitDb("testdb")
+	defer db.Close()
+	opts := sc.TableOptions{PrimaryKey: "Id", Indexes: map[string]sc.IndexOptions{"Token": {Unique: false}}, DefaultTTL: time.Hour}
+	table, _ := db.AddTableWithOptions("sessions", opts)
+
+	table.InsertData(ttlTestObj{Id: "Id1", Token: "tok"}, ttlTestObj{Id: "Id2", Token: "tok"})
+	table.InsertDataWithTTL(3 * time.Hour, ttlTestObj{Id: "Id3", Token: "tok"})
+	if len(table.LookupAll("Token", "tok")) != 3 {
+		fmt.Println("FAIL: DefaultTTL before expiry")
+		t.Fail()
+	}
+	// sweep as if two hours had gone by rather than waiting for them
+	if removed := table.RemoveExpired(time.Now().Add(2 * time.Hour)); removed != 2 {
+		fmt.Println("FAIL: DefaultTTL rows expiring", removed)
+		t.Fail()
+	}
+	// expired but not swept yet
+	table.InsertDataWithTTL(time.Nanosecond, ttlTestObj{Id: "Id4", Token: "tok"})
+	if all := table.LookupAll("Token", "tok"); len(all) != 1 || table.LookupKey("tok", "Token") != all[0] {
+		fmt.Println("FAIL: DefaultTTL after expiry", all)
+		t.Fail()
+	}
+	if count := table.DeleteWhere(func(row interface{}) bool { return true }); count != 1 {
+		fmt.Println("FAIL: DeleteWhere counted expired rows", count)
+		t.Fail()
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	defer sc.SetJanitorInterval(10 * time.Millisecond)()
+	db := sc.InitDb("testdb")
+	table, _ := db.AddTable("sessions", "Id", "Token")
+	for i := 0; i < 10; i++ {
+		table.SetDataWithTTL(20 * time.Millisecond, ttlTestObj{Id: fmt.Sprintf("Id%d", i), Token: fmt.Sprintf("tok%d", i)})
+	}
+	table.SetData(ttlTestObj{Id: "keep", Token: "keep"})
+
+	// janitor should empty the indexes without anyone writing to the table
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && sc.StoredRows(table) > 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := db.Close(); err != nil {
+		t.Fail()
+	}
+	// safe to read the index maps directly once the janitor has stopped
+	if len(table.Indexes["Id"].Idx) != 1 || len(table.Indexes["Token"].Idx) != 1 {
+		fmt.Println("FAIL: Janitor didn't remove expired rows", len(table.Indexes["Id"].Idx))
+		t.Fail()
+	}
+
+	// closing twice is fine, and so is closing a db which never started a janitor
+	db.Close()
+	sc.InitDb("other").Close()
+}
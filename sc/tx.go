@@ -0,0 +1,500 @@
+package sc
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A transaction over every table in a db, handed to the function given to Update or View. Get at a table with Table.
+// The transaction holds the lock of every table in the db until the function returns, taken in name order so two
+// transactions can't deadlock. So nobody else sees a write until they all go in together, and reads inside see the
+// tables exactly as they were plus the transaction's own writes. Only use the tables through the Tx: calling the
+// Table methods or Update and View from inside the function deadlocks
+type Tx struct {
+	db *Database
+	tables map[string]*Table
+	// table names in the order their locks were taken
+	names []string
+	writable bool
+	// set once the function returns, after which the Tx can't be used
+	closed bool
+}
+
+// A table as seen from inside a transaction. Has the same methods as Table, minus the ones changing its layout
+type TxTable struct {
+	tx *Tx
+	tbl *Table
+}
+
+// What a change to a record was, so the transaction can undo it
+type undoOp int
+
+const (
+	undoInsert undoOp = iota
+	undoWrite
+	undoDelete
+	undoExpiry
+	// another record took one of rec's unique keys
+	undoKey
+)
+
+// One change made by a transaction, with what the record held before it
+type undoEntry struct {
+	op undoOp
+	rec *record
+	was record
+	// the unique index and key rec lost, for undoKey
+	index string
+	key interface{}
+	// where rec was in each sorted or ordered index, for undoWrite and undoDelete, so a rollback puts it back in the
+	// same place among rows with the same key
+	nodes map[string]skipPos
+}
+
+// A unique key held by a record which another one is about to take over
+type heldKey struct {
+	index string
+	key interface{}
+	rec *record
+}
+
+// Run fn in a read write transaction. If fn returns nil everything it wrote becomes visible at once. If it returns an
+// error or panics every write is undone, as if it never ran, and the error is returned or the panic carries on.
+// With a db from Open every write goes into the log as a single record, and is undone if that fails.
+// Rows evicted along the way are only handed to OnEvict if the transaction goes through. A rollback puts back the
+// rows but not the evictor's order, so rows it brings back count as just written when choosing what to evict next.
+// eg. to write a user and an audit row together, or neither:
+// db.Update(func(tx *sc.Tx) error { users, _ := tx.Table("users"); ...; return audit.InsertData(entry) })
+func (db *Database) Update(fn func(tx *Tx) error) error {
+	tx := db.begin(true)
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.log(); err != nil {
+		return err
+	}
+	committed = true
+	tx.release()
+	return nil
+}
+
+// Run fn in a read only transaction. Reads see every table as of the same moment, writes fail with ErrReadOnly.
+// Any number of View transactions can run at once, but they hold up writers until they return
+func (db *Database) View(fn func(tx *Tx) error) error {
+	tx := db.begin(false)
+	defer tx.release()
+	return fn(tx)
+}
+
+// Lock every table in the db in name order. Tables added after this aren't part of the transaction
+func (db *Database) begin(writable bool) *Tx {
+	db.mu.RLock()
+	tx := &Tx{db: db, tables: make(map[string]*Table, len(db.Tables)), writable: writable}
+	for name, tbl := range db.Tables {
+		tx.tables[name] = tbl
+		tx.names = append(tx.names, name)
+	}
+	db.mu.RUnlock()
+	sort.Strings(tx.names)
+	for _, name := range tx.names {
+		tbl := tx.tables[name]
+		if writable {
+			tbl.mu.Lock()
+			tbl.inTx = true
+		} else {
+			tbl.mu.RLock()
+		}
+	}
+	db.logger.Debug("begin", "db", db.Name, "writable", writable, "tables", len(tx.names))
+	return tx
+}
+
+// Append every write in the transaction to the db's log as one record, so they are replayed all or nothing
+func (tx *Tx) log() error {
+	var ops []walOp
+	for _, name := range tx.names {
+		if tbl := tx.tables[name]; tbl.wal != nil {
+			ops = append(ops, tbl.changes()...)
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := tx.db.wal.append(ops); err != nil {
+		tx.db.logger.Error("wal", "db", tx.db.Name, "err", err)
+		return err
+	}
+	return nil
+}
+
+// Undo every write in the transaction, newest first, then release the tables
+func (tx *Tx) rollback() {
+	for i := len(tx.names) - 1; i >= 0; i-- {
+		tx.tables[tx.names[i]].rollback()
+	}
+	tx.release()
+}
+
+// Unlock every table and tell OnEvict about rows evicted by a transaction which went through. Callbacks run once
+// every lock is released, so they are free to use any table
+func (tx *Tx) release() {
+	tx.closed = true
+	evicted := make(map[*Table][]evictedRow)
+	for i := len(tx.names) - 1; i >= 0; i-- {
+		tbl := tx.tables[tx.names[i]]
+		if !tx.writable {
+			tbl.mu.RUnlock()
+			continue
+		}
+		tbl.inTx = false
+		tbl.undo = nil
+		if len(tbl.evicted) > 0 {
+			evicted[tbl] = tbl.evicted
+			tbl.evicted = nil
+		}
+		tbl.mu.Unlock()
+	}
+	for tbl, rows := range evicted {
+		for _, e := range rows {
+			tbl.onEvict(e.row, e.reason)
+		}
+	}
+}
+
+// Get a table in the transaction by name. ok is false if there was no such table when the transaction started
+func (tx *Tx) Table(name string) (*TxTable, bool) {
+	tbl, ok := tx.tables[name]
+	if !ok {
+		return nil, false
+	}
+	return &TxTable{tx: tx, tbl: tbl}, true
+}
+
+// Whether the transaction can write, ie. it came from Update rather than View
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Make sure the transaction is still open, and can write if write is set
+func (tt *TxTable) check(write bool) error {
+	if tt.tx.closed {
+		return errors.Wrapf(ErrTxClosed, "Table %s", tt.tbl.Name)
+	}
+	if write && !tt.tx.writable {
+		return errors.Wrapf(ErrReadOnly, "Can't write to table %s in a View transaction", tt.tbl.Name)
+	}
+	return nil
+}
+
+// Same as Table.InsertData
+func (tt *TxTable) InsertData(data... interface{}) error {
+	if err := tt.check(true); err != nil {
+		return err
+	}
+	return tt.tbl.insertData(tt.tbl.defaultTTL, data...)
+}
+
+// Same as Table.SetData
+func (tt *TxTable) SetData(data... interface{}) error {
+	if err := tt.check(true); err != nil {
+		return err
+	}
+	return tt.tbl.addData(tt.tbl.defaultTTL, data...)
+}
+
+// Same as Table.SetDataWithTTL
+func (tt *TxTable) SetDataWithTTL(ttl time.Duration, data... interface{}) error {
+	if err := tt.check(true); err != nil {
+		return err
+	}
+	return tt.tbl.addData(ttl, data...)
+}
+
+// Same as Table.InsertDataWithTTL
+func (tt *TxTable) InsertDataWithTTL(ttl time.Duration, data... interface{}) error {
+	if err := tt.check(true); err != nil {
+		return err
+	}
+	return tt.tbl.insertData(ttl, data...)
+}
+
+// Same as Table.UpdateData
+func (tt *TxTable) UpdateData(data... interface{}) error {
+	if err := tt.check(true); err != nil {
+		return err
+	}
+	return tt.tbl.updateBy(tt.tbl.pk, data...)
+}
+
+// Same as Table.UpdateBy
+func (tt *TxTable) UpdateBy(index string, data... interface{}) error {
+	if err := tt.check(true); err != nil {
+		return err
+	}
+	return tt.tbl.updateBy(index, data...)
+}
+
+// Same as Table.Patch
+func (tt *TxTable) Patch(index string, key interface{}, changes map[string]interface{}) (before, after interface{}, err error) {
+	return tt.Modify(index, key, func(row interface{}) (interface{}, error) {
+		return patchRow(row, changes)
+	})
+}
+
+// Same as Table.Modify. change must not use this table, through the transaction or otherwise
+func (tt *TxTable) Modify(index string, key interface{}, change func(row interface{}) (interface{}, error)) (before, after interface{}, err error) {
+	if err := tt.check(true); err != nil {
+		return nil, nil, err
+	}
+	return tt.tbl.modify(index, key, change)
+}
+
+// Same as Table.CompareAndSwap. A row written earlier in the transaction is already at a new version
+func (tt *TxTable) CompareAndSwap(index string, key interface{}, expectedVersion uint64, newRow interface{}) (uint64, error) {
+	if err := tt.check(true); err != nil {
+		return 0, err
+	}
+	return tt.tbl.compareAndSwap(index, key, expectedVersion, newRow)
+}
+
+// Same as Table.UpdateIfVersion
+func (tt *TxTable) UpdateIfVersion(expectedVersion uint64, data interface{}) (uint64, error) {
+	if err := tt.check(true); err != nil {
+		return 0, err
+	}
+	return tt.tbl.updateIfVersion(expectedVersion, data)
+}
+
+// Same as Table.Delete
+func (tt *TxTable) Delete(index string, key interface{}) (interface{}, error) {
+	if err := tt.check(true); err != nil {
+		return nil, err
+	}
+	return tt.tbl.delete(index, key)
+}
+
+// Same as Table.DeleteWhere, but fails outside a read write transaction
+func (tt *TxTable) DeleteWhere(predicate func(row interface{}) bool) (int, error) {
+	if err := tt.check(true); err != nil {
+		return 0, err
+	}
+	return tt.tbl.deleteWhere(predicate), nil
+}
+
+// Same as Table.LookupKey. nil once the transaction is over
+func (tt *TxTable) LookupKey(key interface{}, idx string) interface{} {
+	if tt.check(false) != nil {
+		return nil
+	}
+	return tt.tbl.lookupKey(key, idx)
+}
+
+// Same as Table.LookupAll. nil once the transaction is over
+func (tt *TxTable) LookupAll(idx string, key interface{}) []interface{} {
+	if tt.check(false) != nil {
+		return nil
+	}
+	return tt.tbl.results(tt.tbl.Indexes[idx].lookup(key))
+}
+
+// Same as Table.Get. ok is false once the transaction is over
+func (tt *TxTable) Get(index string, key interface{}) (row interface{}, version uint64, ok bool) {
+	if tt.check(false) != nil {
+		return nil, 0, false
+	}
+	rec := tt.tbl.lookupRecord(key, index)
+	if rec == nil {
+		return nil, 0, false
+	}
+	return tt.tbl.rowOut(rec), rec.version, true
+}
+
+// Same as Table.Range. Fails with ErrTxClosed once the transaction is over
+func (tt *TxTable) Range(index string, lo, hi interface{}, opts RangeOptions) ([]interface{}, error) {
+	if err := tt.check(false); err != nil {
+		return nil, err
+	}
+	return tt.tbl.rangeRows(index, lo, hi, opts)
+}
+
+// Same as Table.Prefix. Fails with ErrTxClosed once the transaction is over
+func (tt *TxTable) Prefix(index string, prefix string, opts RangeOptions) ([]interface{}, error) {
+	if err := tt.check(false); err != nil {
+		return nil, err
+	}
+	return tt.tbl.prefixRows(index, prefix, opts)
+}
+
+// Same as Table.Rank. ok is false once the transaction is over
+func (tt *TxTable) Rank(index string, key interface{}) (rank int, ok bool) {
+	if tt.check(false) != nil {
+		return 0, false
+	}
+	return tt.tbl.rank(index, key)
+}
+
+// Same as Table.Score. ok is false once the transaction is over
+func (tt *TxTable) Score(index string, key interface{}) (score float64, ok bool) {
+	if tt.check(false) != nil {
+		return 0, false
+	}
+	return tt.tbl.score(index, key)
+}
+
+// Same as Table.RangeByRank. Fails with ErrTxClosed once the transaction is over
+func (tt *TxTable) RangeByRank(index string, start, stop int) ([]interface{}, error) {
+	if err := tt.check(false); err != nil {
+		return nil, err
+	}
+	return tt.tbl.rangeByRank(index, start, stop)
+}
+
+// Same as Table.RangeByScore. Fails with ErrTxClosed once the transaction is over
+func (tt *TxTable) RangeByScore(index string, min, max float64) ([]interface{}, error) {
+	if err := tt.check(false); err != nil {
+		return nil, err
+	}
+	return tt.tbl.rangeByScore(index, min, max)
+}
+
+// Remember how to undo a change about to be made to a record, if a transaction holds the table or it has a log which
+// the change needs to go to. Live snapshots get the record's old version too. Caller must hold the table write lock
+func (tbl *Table) logUndo(op undoOp, rec *record) {
+	tbl.remember(rec, op == undoInsert)
+	if !tbl.inTx && tbl.wal == nil {
+		return
+	}
+	u := undoEntry{op: op, rec: rec, was: *rec}
+	if op == undoWrite || op == undoDelete {
+		u.nodes = tbl.nodesOf(rec)
+	}
+	tbl.undo = append(tbl.undo, u)
+}
+
+// Remember which records are about to lose unique keys to rec, which is being stored under the keys for fields, so a
+// rollback can give them back and live snapshots still find them under those keys. Caller must hold the table write
+// lock
+func (tbl *Table) logDisplaced(rec *record, fields fieldValues) {
+	if !tbl.inTx && tbl.wal == nil && len(tbl.snapshots) == 0 {
+		return
+	}
+	for _, h := range tbl.heldKeys(rec, fields) {
+		tbl.remember(h.rec, false)
+		if !tbl.inTx && tbl.wal == nil {
+			continue
+		}
+		tbl.undo = append(tbl.undo, undoEntry{op: undoKey, rec: h.rec, was: *h.rec, index: h.index, key: h.key})
+	}
+}
+
+// Unique keys for fields which some record other than rec holds. Caller must hold the table lock
+func (tbl *Table) heldKeys(rec *record, fields fieldValues) []heldKey {
+	var held []heldKey
+	for name, idx := range tbl.Indexes {
+		if !idx.Unique {
+			continue
+		}
+		for _, key := range idx.keysOf(fields) {
+			if other, ok := idx.get(key); ok && other != rec {
+				held = append(held, heldKey{index: name, key: key, rec: other})
+			}
+		}
+	}
+	return held
+}
+
+// Put unique keys back with the records which held them. Only the map is touched since a record stays in the skip
+// list of a sorted index whoever holds its key. Caller must hold the table write lock
+func (tbl *Table) giveBack(held []heldKey) {
+	for _, h := range held {
+		if idx, ok := tbl.Indexes[h.index]; ok && tbl.rows[h.rec.id] == h.rec {
+			idx.Idx[h.key] = h.rec
+		}
+	}
+}
+
+// Undo every change logged since the transaction or write started, newest first, leaving the table as it was. Row
+// versions handed out in the meantime aren't reused. Caller must hold the table write lock
+func (tbl *Table) rollback() {
+	tbl.inTx = false
+	// reverting logs changes of its own, which are dropped along with the rest
+	undo := tbl.undo
+	for i := len(undo) - 1; i >= 0; i-- {
+		tbl.revert(undo[i])
+	}
+	tbl.undo = nil
+	// evicted rows are back so OnEvict mustn't hear about them
+	tbl.evicted = nil
+	tbl.db.logger.Debug("rollback", "table", tbl.Name)
+}
+
+// Undo a single change. Caller must hold the table write lock
+func (tbl *Table) revert(u undoEntry) {
+	rec := u.rec
+	switch u.op {
+	case undoInsert:
+		tbl.deleteRecord(rec)
+	case undoWrite:
+		old := fieldsOf(rec.row)
+		pk := rec.pk
+		rec.pk, rec.row, rec.version, rec.keys = u.was.pk, u.was.row, u.was.version, u.was.keys
+		// a key it didn't hold before the write stays with whoever holds it now
+		fields := fieldsOf(rec.row)
+		held := tbl.heldKeys(rec, fields)
+		tbl.rekey(rec, old, fields)
+		tbl.giveBack(held)
+		tbl.reposition(rec, u.nodes)
+		if rec.pk != pk && tbl.evictor != nil {
+			tbl.evictor.Removed(pk)
+			tbl.track(rec, false)
+		} else {
+			tbl.track(rec, true)
+		}
+	case undoDelete:
+		tbl.rows[rec.id] = rec
+		fields := fieldsOf(rec.row)
+		held := tbl.heldKeys(rec, fields)
+		tbl.link(rec, fields)
+		tbl.giveBack(held)
+		tbl.reposition(rec, u.nodes)
+		tbl.restoreExpiry(rec, rec.expires)
+		// untrack took its size off the total, so it counts as new
+		rec.size = 0
+		tbl.track(rec, false)
+	case undoExpiry:
+		tbl.restoreExpiry(rec, u.was.expires)
+	case undoKey:
+		tbl.giveBack([]heldKey{{index: u.index, key: u.key, rec: rec}})
+	}
+}
+
+// Move a record back to where it was in sorted and ordered indexes, since linking it again puts it after every row
+// with the same key. Caller must hold the table write lock
+func (tbl *Table) reposition(rec *record, nodes map[string]skipPos) {
+	for name, pos := range nodes {
+		if idx, ok := tbl.Indexes[name]; ok && idx.sorted != nil {
+			idx.sorted.remove(rec.id)
+			idx.sorted.insertAt(pos.key, pos.seq, rec)
+		}
+	}
+}
+
+// Put back a record's expiry time as it was. Caller must hold the table write lock
+func (tbl *Table) restoreExpiry(rec *record, expires time.Time) {
+	rec.expires = expires
+	if expires.IsZero() {
+		delete(tbl.expiring, rec.id)
+		return
+	}
+	tbl.expiring[rec.id] = rec
+	heap.Push(&tbl.expiryQueue, expiryItem{at: expires, rec: rec})
+}
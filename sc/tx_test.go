@@ -0,0 +1,366 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"errors"
+	"sync"
+	"time"
+)
+
+type txUser struct {
+	Id int
+	Username string
+	Score int
+}
+
+type txAudit struct {
+	Id int
+	UserId int
+}
+
+func txTestDb(opts sc.TableOptions) (*sc.Database, *sc.Table, *sc.Table) {
+	db := sc.InitDb("testdb")
+	opts.PrimaryKey = "Id"
+	opts.Indexes = map[string]sc.IndexOptions{"Username": {Unique: true}, "Score": {Kind: sc.SortedIndex}}
+	users, _ := db.AddTableWithOptions("users", opts)
+	audit, _ := db.AddTableWithOptions("audit", sc.TableOptions{PrimaryKey: "Id", Indexes: map[string]sc.IndexOptions{"UserId": {}}})
+	users.InsertData(txUser{1, "alice", 10}, txUser{2, "bob", 20}, txUser{3, "carol", 30})
+	return db, users, audit
+}
+
+func TestUpdateCommit(t *testing.T) {
+	db, users, audit := txTestDb(sc.TableOptions{})
+	err := db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		a, _ := tx.Table("audit")
+		if err := u.UpdateData(txUser{1, "alicia", 10}); err != nil {
+			return err
+		}
+		// reads see the transaction's own writes
+		if u.LookupKey("alicia", "Username") == nil || u.LookupKey("alice", "Username") != nil {
+			return errors.New("write not visible inside the transaction")
+		}
+		return a.InsertData(txAudit{1, 1})
+	})
+	if err != nil || users.LookupKey("alicia", "Username") == nil || audit.LookupKey(1, "Id") == nil {
+		fmt.Println("FAIL: TestUpdateCommit", err)
+		t.Fail()
+	}
+	db.View(func(tx *sc.Tx) error {
+		if _, ok := tx.Table("nope"); ok {
+			fmt.Println("FAIL: TestUpdateCommit missing table")
+			t.Fail()
+		}
+		return nil
+	})
+}
+
+func TestUpdateRollback(t *testing.T) {
+	db, users, audit := txTestDb(sc.TableOptions{DefaultTTL: time.Hour})
+	defer db.Close()
+	_, version, _ := users.Get("Id", 2)
+	failed := errors.New("failed")
+	err := db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		a, _ := tx.Table("audit")
+		a.InsertData(txAudit{1, 1})
+		u.InsertData(txUser{4, "dave", 5})
+		u.UpdateData(txUser{2, "robert", 40})
+		u.SetData(txUser{1, "alice", 50})
+		u.Delete("Id", 3)
+		u.DeleteWhere(func(row interface{}) bool { return row.(txUser).Id == 4 })
+		return failed
+	})
+	if err != failed {
+		fmt.Println("FAIL: TestUpdateRollback error", err)
+		t.Fail()
+	}
+	if sc.GetTableSize(users) != 3 || sc.GetTableSize(audit) != 0 || len(audit.LookupAll("UserId", 1)) != 0 {
+		fmt.Println("FAIL: TestUpdateRollback sizes", sc.GetTableSize(users), sc.GetTableSize(audit))
+		t.Fail()
+	}
+	if users.LookupKey("bob", "Username") == nil || users.LookupKey("robert", "Username") != nil ||
+		users.LookupKey("carol", "Username") == nil || users.LookupKey("dave", "Username") != nil {
+		fmt.Println("FAIL: TestUpdateRollback keys not restored")
+		t.Fail()
+	}
+	for i, pk := range []int{1, 2, 3} {
+		if rank, _ := users.Rank("Score", pk); rank != i {
+			fmt.Println("FAIL: TestUpdateRollback rank", pk, rank)
+			t.Fail()
+		}
+	}
+	if row, v, _ := users.Get("Id", 2); v != version || row.(txUser).Score != 20 {
+		fmt.Println("FAIL: TestUpdateRollback version", v, version)
+		t.Fail()
+	}
+	// expiry times are put back too, so the restored rows still expire
+	if n := users.RemoveExpired(time.Now().Add(2 * time.Hour)); n != 3 {
+		fmt.Println("FAIL: TestUpdateRollback expiry", n)
+		t.Fail()
+	}
+}
+
+// Keys taken from other rows by SetData go back to them
+func TestUpdateRollbackUniqueKeys(t *testing.T) {
+	db, users, _ := txTestDb(sc.TableOptions{})
+	failed := errors.New("failed")
+	db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		u.SetData(txUser{4, "alice", 40})
+		u.SetData(txUser{2, "carol", 20})
+		return failed
+	})
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if row, ok := users.LookupKey(name, "Username").(txUser); !ok || row.Username != name {
+			fmt.Println("FAIL: TestUpdateRollbackUniqueKeys key not given back", name, row)
+			t.Fail()
+		}
+	}
+
+	// a row which had already lost its key outside the transaction doesn't get it back
+	users.SetData(txUser{5, "alice", 50})
+	db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		u.Delete("Id", 1)
+		u.UpdateData(txUser{5, "eve", 50})
+		return failed
+	})
+	if row := users.LookupKey("alice", "Username"); row == nil || row.(txUser).Id != 5 || users.LookupKey(1, "Id") == nil {
+		fmt.Println("FAIL: TestUpdateRollbackUniqueKeys key stolen back", row)
+		t.Fail()
+	}
+}
+
+// Rows written or deleted then rolled back keep their place among rows with the same score
+func TestUpdateRollbackTieOrder(t *testing.T) {
+	db := sc.InitDb("testdb")
+	users, _ := db.AddTableWithOptions("users", sc.TableOptions{PrimaryKey: "Id", Indexes: map[string]sc.IndexOptions{"Score": {Kind: sc.SortedIndex}}})
+	users.InsertData(txUser{1, "alice", 5}, txUser{2, "bob", 5}, txUser{3, "carol", 5})
+	db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		u.SetData(txUser{1, "alicia", 5})
+		u.Delete("Id", 2)
+		return errors.New("failed")
+	})
+	rows, _ := users.RangeByRank("Score", 0, -1)
+	if fmt.Sprint(rows) != "[{1 alice 5} {2 bob 5} {3 carol 5}]" {
+		fmt.Println("FAIL: TestUpdateRollbackTieOrder order", rows)
+		t.Fail()
+	}
+	if rank, _ := users.Rank("Score", 1); rank != 0 {
+		fmt.Println("FAIL: TestUpdateRollbackTieOrder rank", rank)
+		t.Fail()
+	}
+	if rank, _ := users.Rank("Score", 2); rank != 1 {
+		fmt.Println("FAIL: TestUpdateRollbackTieOrder deleted rank", rank)
+		t.Fail()
+	}
+}
+
+func TestUpdatePanic(t *testing.T) {
+	db, users, _ := txTestDb(sc.TableOptions{})
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				fmt.Println("FAIL: TestUpdatePanic panic lost", r)
+				t.Fail()
+			}
+		}()
+		db.Update(func(tx *sc.Tx) error {
+			u, _ := tx.Table("users")
+			u.UpdateData(txUser{1, "alicia", 10})
+			panic("boom")
+		})
+	}()
+	if users.LookupKey("alice", "Username") == nil {
+		fmt.Println("FAIL: TestUpdatePanic not rolled back")
+		t.Fail()
+	}
+	// the locks were released
+	if err := users.InsertData(txUser{4, "dave", 40}); err != nil {
+		fmt.Println("FAIL: TestUpdatePanic", err)
+		t.Fail()
+	}
+}
+
+func TestView(t *testing.T) {
+	db, _, _ := txTestDb(sc.TableOptions{})
+	var after *sc.TxTable
+	db.View(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		if err := u.InsertData(txUser{4, "dave", 40}); !errors.Is(err, sc.ErrReadOnly) || tx.Writable() {
+			fmt.Println("FAIL: TestView write", err)
+			t.Fail()
+		}
+		if len(u.LookupAll("Username", "bob")) != 1 {
+			fmt.Println("FAIL: TestView read")
+			t.Fail()
+		}
+		after = u
+		return nil
+	})
+	if _, err := after.Delete("Id", 1); !errors.Is(err, sc.ErrTxClosed) || after.LookupKey(1, "Id") != nil {
+		fmt.Println("FAIL: TestView used after it returned", err)
+		t.Fail()
+	}
+}
+
+// The rest of the Table methods work inside a transaction and are rolled back with it
+func TestUpdateTableMethods(t *testing.T) {
+	db := sc.InitDb("testdb")
+	users, _ := db.AddTableWithOptions("users", sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{"Username": {Kind: sc.OrderedIndex}, "Score": {Kind: sc.SortedIndex}},
+	})
+	users.InsertData(txUser{1, "alice", 10}, txUser{2, "bob", 20}, txUser{3, "carol", 30})
+	failed := errors.New("failed")
+	for _, fail := range []bool{true, false} {
+		err := db.Update(func(tx *sc.Tx) error {
+			u, _ := tx.Table("users")
+			if _, after, err := u.Patch("Id", 1, map[string]interface{}{"Score": 40}); err != nil || after.(txUser).Score != 40 {
+				return fmt.Errorf("patch %v %v", after, err)
+			}
+			if _, _, err := u.Modify("Id", 2, func(row interface{}) (interface{}, error) {
+				user := row.(txUser)
+				user.Username = "bobby"
+				return user, nil
+			}); err != nil {
+				return err
+			}
+			_, version, _ := u.Get("Id", 3)
+			if _, err := u.CompareAndSwap("Id", 3, version - 1, txUser{3, "carl", 30}); !errors.Is(err, sc.ErrVersionConflict) {
+				return fmt.Errorf("stale compare and swap %v", err)
+			}
+			version, err := u.CompareAndSwap("Id", 3, version, txUser{3, "carl", 30})
+			if err != nil {
+				return err
+			}
+			if _, err := u.UpdateIfVersion(version, txUser{3, "carl", 35}); err != nil {
+				return err
+			}
+			if err := u.SetDataWithTTL(time.Hour, txUser{4, "dave", 50}); err != nil {
+				return err
+			}
+			if err := u.InsertDataWithTTL(time.Hour, txUser{5, "bo", 5}); err != nil {
+				return err
+			}
+			// reads see the writes above
+			if rows, _ := u.Prefix("Username", "bo", sc.RangeOptions{}); fmt.Sprint(rows) != "[{5 bo 5} {2 bobby 20}]" {
+				return fmt.Errorf("prefix %v", rows)
+			}
+			if rows, _ := u.Range("Username", "c", "d", sc.RangeOptions{}); fmt.Sprint(rows) != "[{3 carl 35}]" {
+				return fmt.Errorf("range %v", rows)
+			}
+			if rows, _ := u.RangeByRank("Score", -2, -1); fmt.Sprint(rows) != "[{1 alice 40} {4 dave 50}]" {
+				return fmt.Errorf("range by rank %v", rows)
+			}
+			if rows, _ := u.RangeByScore("Score", 20, 35); len(rows) != 2 {
+				return fmt.Errorf("range by score %v", rows)
+			}
+			if rank, _ := u.Rank("Score", 5); rank != 0 {
+				return fmt.Errorf("rank %d", rank)
+			}
+			if score, _ := u.Score("Score", 1); score != 40 {
+				return fmt.Errorf("score %v", score)
+			}
+			if fail {
+				return failed
+			}
+			return nil
+		})
+		if fail && err != failed || !fail && err != nil {
+			fmt.Println("FAIL: TestUpdateTableMethods", fail, err)
+			t.Fail()
+		}
+		want := "[{5 bo 5} {2 bobby 20} {3 carl 35} {1 alice 40} {4 dave 50}]"
+		if fail {
+			want = "[{1 alice 10} {2 bob 20} {3 carol 30}]"
+		}
+		if rows, _ := users.RangeByRank("Score", 0, -1); fmt.Sprint(rows) != want {
+			fmt.Println("FAIL: TestUpdateTableMethods after", fail, rows)
+			t.Fail()
+		}
+	}
+
+	db.View(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		if _, _, err := u.Patch("Id", 1, map[string]interface{}{"Score": 1}); !errors.Is(err, sc.ErrReadOnly) {
+			fmt.Println("FAIL: TestUpdateTableMethods view patch", err)
+			t.Fail()
+		}
+		if _, err := u.UpdateIfVersion(1, txUser{1, "alice", 1}); !errors.Is(err, sc.ErrReadOnly) {
+			fmt.Println("FAIL: TestUpdateTableMethods view update", err)
+			t.Fail()
+		}
+		if err := u.SetDataWithTTL(time.Hour, txUser{6, "erin", 60}); !errors.Is(err, sc.ErrReadOnly) {
+			fmt.Println("FAIL: TestUpdateTableMethods view set", err)
+			t.Fail()
+		}
+		return nil
+	})
+}
+
+// Readers never see a user without its audit row
+func TestUpdateAtomic(t *testing.T) {
+	db, _, _ := txTestDb(sc.TableOptions{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 10; i < 200; i++ {
+			db.Update(func(tx *sc.Tx) error {
+				u, _ := tx.Table("users")
+				a, _ := tx.Table("audit")
+				u.InsertData(txUser{i, fmt.Sprint("user", i), i})
+				return a.InsertData(txAudit{i, i})
+			})
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		db.View(func(tx *sc.Tx) error {
+			u, _ := tx.Table("users")
+			a, _ := tx.Table("audit")
+			for id := 10; id < 200; id++ {
+				if (u.LookupKey(id, "Id") == nil) != (a.LookupKey(id, "Id") == nil) {
+					fmt.Println("FAIL: TestUpdateAtomic half a transaction seen", id)
+					t.Fail()
+				}
+			}
+			return nil
+		})
+	}
+	wg.Wait()
+}
+
+// Rows evicted by a transaction come back if it rolls back, and OnEvict only hears about them if it commits
+func TestUpdateEviction(t *testing.T) {
+	var mu sync.Mutex
+	var evicted []interface{}
+	db, users, _ := txTestDb(sc.TableOptions{MaxRows: 3, OnEvict: func(row interface{}, reason sc.EvictReason) {
+		mu.Lock()
+		evicted = append(evicted, row)
+		mu.Unlock()
+	}})
+	insert := func(err error) error {
+		return db.Update(func(tx *sc.Tx) error {
+			u, _ := tx.Table("users")
+			if e := u.InsertData(txUser{4, "dave", 40}); e != nil {
+				return e
+			}
+			return err
+		})
+	}
+	insert(errors.New("failed"))
+	if sc.GetTableSize(users) != 3 || users.LookupKey(1, "Id") == nil || len(evicted) != 0 {
+		fmt.Println("FAIL: TestUpdateEviction rolled back", sc.GetTableSize(users), evicted)
+		t.Fail()
+	}
+	insert(nil)
+	if sc.GetTableSize(users) != 3 || users.LookupKey(4, "Id") == nil || len(evicted) != 1 {
+		fmt.Println("FAIL: TestUpdateEviction committed", sc.GetTableSize(users), evicted)
+		t.Fail()
+	}
+}
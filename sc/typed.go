@@ -0,0 +1,220 @@
+package sc
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Typed wrapper around Table so callers get their own struct back instead of interface{} and don't need .(T) everywhere.
+// Only T rows can go in, which is checked by the compiler rather than at runtime.
+// Uses the same index machinery as Table underneath so locking and uniqueness behave exactly the same.
+type TypedTable[T any] struct {
+	tbl *Table
+}
+
+// Create a table in the db which only holds rows of type T
+// T (or what it points to if T is a pointer type) must be a struct with an exported field for every index
+// eg. users, err := sc.NewTable[User](db, "users", "Id", "Username")
+func NewTable[T any](db *Database, tableName string, indexes... string) (*TypedTable[T], error) {
+	if err := typeHasFields(reflect.TypeOf((*T)(nil)).Elem(), indexes); err != nil {
+		return nil, err
+	}
+	tbl, err := db.AddTable(tableName, indexes...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedTable[T]{tbl: tbl}, nil
+}
+
+// Create a table of T rows with a primary key and unique or non unique secondary indexes, see AddTableWithOptions
+func NewTableWithOptions[T any](db *Database, tableName string, opts TableOptions) (*TypedTable[T], error) {
+	if err := typeHasFields(reflect.TypeOf((*T)(nil)).Elem(), opts.fields()); err != nil {
+		return nil, err
+	}
+	tbl, err := db.AddTableWithOptions(tableName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedTable[T]{tbl: tbl}, nil
+}
+
+// Get the underlying untyped table eg. for GetTableSize or ListIndexNames
+func (tt *TypedTable[T]) Table() *Table {
+	return tt.tbl
+}
+
+// Look up a row by key in the given index. ok is false if there is no row for that key
+func (tt *TypedTable[T]) Get(index string, key interface{}) (row T, ok bool) {
+	row, ok = tt.tbl.LookupKey(key, index).(T)
+	return row, ok
+}
+
+// Get every row stored under key in the given index, mostly useful for non unique indexes
+func (tt *TypedTable[T]) GetAll(index string, key interface{}) []T {
+	return fromInterfaces[T](tt.tbl.LookupAll(index, key))
+}
+
+// Rows ranked start to stop inclusive in a sorted index, see Table.RangeByRank
+func (tt *TypedTable[T]) RangeByRank(index string, start, stop int) ([]T, error) {
+	data, err := tt.tbl.RangeByRank(index, start, stop)
+	return fromInterfaces[T](data), err
+}
+
+// Rows with a score between min and max inclusive in a sorted index, see Table.RangeByScore
+func (tt *TypedTable[T]) RangeByScore(index string, min, max float64) ([]T, error) {
+	data, err := tt.tbl.RangeByScore(index, min, max)
+	return fromInterfaces[T](data), err
+}
+
+// Insert new rows, failing if any key already exists. Same all or nothing semantics as Table.InsertData
+func (tt *TypedTable[T]) Insert(rows... T) error {
+	return tt.tbl.InsertData(toInterfaces(rows)...)
+}
+
+// Insert rows or overwrite them if they already exist. Same as Table.SetData
+func (tt *TypedTable[T]) Upsert(rows... T) error {
+	return tt.tbl.SetData(toInterfaces(rows)...)
+}
+
+// Insert new rows which expire after ttl. Same as Table.InsertDataWithTTL
+func (tt *TypedTable[T]) InsertWithTTL(ttl time.Duration, rows... T) error {
+	return tt.tbl.InsertDataWithTTL(ttl, toInterfaces(rows)...)
+}
+
+// Insert or overwrite rows which expire after ttl. Same as Table.SetDataWithTTL
+func (tt *TypedTable[T]) UpsertWithTTL(ttl time.Duration, rows... T) error {
+	return tt.tbl.SetDataWithTTL(ttl, toInterfaces(rows)...)
+}
+
+// Update rows which already exist. Same as Table.UpdateData
+func (tt *TypedTable[T]) Update(rows... T) error {
+	return tt.tbl.UpdateData(toInterfaces(rows)...)
+}
+
+// Update rows found by their key in the given unique index, eg. to change their primary key. Same as Table.UpdateBy
+func (tt *TypedTable[T]) UpdateBy(index string, rows... T) error {
+	return tt.tbl.UpdateBy(index, toInterfaces(rows)...)
+}
+
+// Change some fields of the row under key in a unique index. Same as Table.Patch
+func (tt *TypedTable[T]) Patch(index string, key interface{}, changes map[string]interface{}) (before, after T, err error) {
+	b, a, err := tt.tbl.Patch(index, key, changes)
+	if err != nil {
+		return before, after, err
+	}
+	return b.(T), a.(T), nil
+}
+
+// Change the row under key in a unique index in place with a function given a copy of it, eg.
+// users.Modify("Id", 1, func(u *User) error { u.Score++; return nil }). Same as Table.Modify
+func (tt *TypedTable[T]) Modify(index string, key interface{}, change func(row *T) error) (before, after T, err error) {
+	b, a, err := tt.tbl.Modify(index, key, func(row interface{}) (interface{}, error) {
+		r, ok := row.(T)
+		if !ok {
+			return nil, fmt.Errorf("Row %v is a %T not a %T", row, row, r)
+		}
+		if err := change(&r); err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+	if err != nil {
+		return before, after, err
+	}
+	return b.(T), a.(T), nil
+}
+
+// Look up a row along with its version, to write it back later with CompareAndSwap or UpdateIfVersion. See Table.Get
+func (tt *TypedTable[T]) GetWithVersion(index string, key interface{}) (row T, version uint64, ok bool) {
+	r, version, ok := tt.tbl.Get(index, key)
+	row, ok = r.(T)
+	return row, version, ok
+}
+
+// Replace the row under key in a unique index only if it is still at expectedVersion. Same as Table.CompareAndSwap
+func (tt *TypedTable[T]) CompareAndSwap(index string, key interface{}, expectedVersion uint64, row T) (uint64, error) {
+	return tt.tbl.CompareAndSwap(index, key, expectedVersion, row)
+}
+
+// Update a row only if it is still at expectedVersion. Same as Table.UpdateIfVersion
+func (tt *TypedTable[T]) UpdateIfVersion(expectedVersion uint64, row T) (uint64, error) {
+	return tt.tbl.UpdateIfVersion(expectedVersion, row)
+}
+
+// Remove the row found under key in the given index from every index in the table.
+// Returns the removed row and whether there was anything to remove
+func (tt *TypedTable[T]) Delete(index string, key interface{}) (row T, ok bool) {
+	tt.tbl.mu.Lock()
+	defer tt.tbl.unlock()
+	removed := tt.tbl.deleteKey(index, key)
+	if tt.tbl.commit(nil) != nil {
+		return row, false
+	}
+	row, ok = removed.(T)
+	return row, ok
+}
+
+// Remove every row for which predicate returns true. Returns how many rows were removed
+func (tt *TypedTable[T]) DeleteWhere(predicate func(row T) bool) int {
+	return tt.tbl.DeleteWhere(func(row interface{}) bool {
+		r, ok := row.(T)
+		return ok && predicate(r)
+	})
+}
+
+var keyerType = reflect.TypeOf((*Keyer)(nil)).Elem()
+var docType = reflect.TypeOf(map[string]interface{}(nil))
+
+// Box a slice of rows so they can be passed to the untyped Table methods
+func toInterfaces[T any](rows []T) []interface{} {
+	data := make([]interface{}, len(rows))
+	for i, r := range rows {
+		data[i] = r
+	}
+	return data
+}
+
+// Rows with a key between lo and hi inclusive in an ordered index, see Table.Range
+func (tt *TypedTable[T]) Range(index string, lo, hi interface{}, opts RangeOptions) ([]T, error) {
+	data, err := tt.tbl.Range(index, lo, hi, opts)
+	return fromInterfaces[T](data), err
+}
+
+// Rows whose key starts with prefix in an ordered index on a string field, see Table.Prefix
+func (tt *TypedTable[T]) Prefix(index string, prefix string, opts RangeOptions) ([]T, error) {
+	data, err := tt.tbl.Prefix(index, prefix, opts)
+	return fromInterfaces[T](data), err
+}
+
+// Unbox rows coming back from the untyped Table methods, skipping any which aren't T
+func fromInterfaces[T any](data []interface{}) []T {
+	rows := make([]T, 0, len(data))
+	for _, d := range data {
+		if r, ok := d.(T); ok {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// Check a row type has a field for every index, dereferencing pointer types first.
+// Keyers and map rows can only be checked once there's a row so they always pass
+func typeHasFields(typ reflect.Type, fields []string) error {
+	if typ.Implements(keyerType) || typ == docType {
+		return nil
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("Row type %s is not a struct", typ)
+	}
+	info := infoFor(typ)
+	for _, f := range fields {
+		if !info.path(f).ok {
+			return fmt.Errorf("Row type %s has no field %s", typ, f)
+		}
+	}
+	return nil
+}
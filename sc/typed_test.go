@@ -0,0 +1,147 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+)
+
+type typedUser struct {
+	Id string
+	Username string
+	Count int
+}
+
+func TestNewTable(t *testing.T) {
+	db := sc.InitDb("testdb")
+
+	users, err := sc.NewTable[typedUser](db, "users", "Id", "Username")
+	if err != nil || users.Table().Name != "users" || len(db.ListTableNames()) != 1 {
+		fmt.Println("FAIL: TestNewTable create table", err)
+		t.Fail()
+	}
+
+	// same name twice should fail like AddTable
+	if _, err = sc.NewTable[typedUser](db, "users", "Id"); err == nil {
+		fmt.Println("FAIL: TestNewTable same table name twice")
+		t.Fail()
+	}
+
+	// index which isn't a field on the type should be caught up front
+	if _, err = sc.NewTable[typedUser](db, "users2", "Id", "Email"); err == nil {
+		fmt.Println("FAIL: TestNewTable missing index field")
+		t.Fail()
+	}
+
+	// only structs can be rows
+	if _, err = sc.NewTable[string](db, "strings", "Id"); err == nil {
+		fmt.Println("FAIL: TestNewTable non struct row type")
+		t.Fail()
+	}
+
+	// pointers to structs are fine
+	if _, err = sc.NewTable[*typedUser](db, "userPtrs", "Id", "Username"); err != nil {
+		fmt.Println("FAIL: TestNewTable pointer row type", err)
+		t.Fail()
+	}
+}
+
+func TestTypedTable(t *testing.T) {
+	db := sc.InitDb("testdb")
+	users, _ := sc.NewTable[typedUser](db, "users", "Id", "Username")
+
+	u1 := typedUser{Id: "id1", Username: "user1", Count: 1}
+	u2 := typedUser{Id: "id2", Username: "user2", Count: 2}
+	if err := users.Insert(u1, u2); err != nil {
+		fmt.Println("FAIL: TestTypedTable insert", err)
+		t.Fail()
+	}
+
+	// no type assertions needed
+	got, ok := users.Get("Username", "user2")
+	if !ok || got != u2 {
+		fmt.Println("FAIL: TestTypedTable get", got, ok)
+		t.Fail()
+	}
+	if _, ok = users.Get("Id", "nope"); ok {
+		fmt.Println("FAIL: TestTypedTable get missing key")
+		t.Fail()
+	}
+
+	// insert is still unique
+	if err := users.Insert(typedUser{Id: "id1", Username: "user3"}); err == nil {
+		fmt.Println("FAIL: TestTypedTable insert duplicate")
+		t.Fail()
+	}
+
+	// update only works on existing rows
+	u1.Count = 10
+	if err := users.Update(u1); err != nil {
+		fmt.Println("FAIL: TestTypedTable update", err)
+		t.Fail()
+	}
+	if got, _ = users.Get("Id", "id1"); got.Count != 10 {
+		fmt.Println("FAIL: TestTypedTable update value", got)
+		t.Fail()
+	}
+	if err := users.Update(typedUser{Id: "id9", Username: "user9"}); err == nil {
+		fmt.Println("FAIL: TestTypedTable update missing row")
+		t.Fail()
+	}
+
+	// upsert adds or overwrites
+	u3 := typedUser{Id: "id3", Username: "user3", Count: 3}
+	u2.Count = 20
+	if err := users.Upsert(u2, u3); err != nil {
+		fmt.Println("FAIL: TestTypedTable upsert", err)
+		t.Fail()
+	}
+	if got, _ = users.Get("Id", "id2"); got.Count != 20 || sc.GetTableSize(users.Table()) != 3 {
+		fmt.Println("FAIL: TestTypedTable upsert value", got)
+		t.Fail()
+	}
+
+	// delete removes from every index
+	removed, ok := users.Delete("Username", "user3")
+	if !ok || removed != u3 || sc.GetTableSize(users.Table()) != 2 {
+		fmt.Println("FAIL: TestTypedTable delete", removed, ok)
+		t.Fail()
+	}
+	if _, ok = users.Get("Id", "id3"); ok {
+		fmt.Println("FAIL: TestTypedTable delete left row in other index")
+		t.Fail()
+	}
+	if _, ok = users.Delete("Id", "id3"); ok {
+		fmt.Println("FAIL: TestTypedTable delete missing row")
+		t.Fail()
+	}
+
+	// delete where
+	users.Upsert(typedUser{Id: "id4", Username: "user4", Count: 4}, typedUser{Id: "id5", Username: "user5", Count: 5})
+	count := users.DeleteWhere(func(u typedUser) bool { return u.Count < 10 })
+	if count != 2 || sc.GetTableSize(users.Table()) != 2 {
+		fmt.Println("FAIL: TestTypedTable delete where", count)
+		t.Fail()
+	}
+}
+
+func TestTypedTableNonUnique(t *testing.T) {
+	db := sc.InitDb("testdb")
+	opts := sc.TableOptions{PrimaryKey: "Id", Indexes: map[string]sc.IndexOptions{"Count": {Unique: false}}}
+	users, err := sc.NewTableWithOptions[typedUser](db, "users", opts)
+	if err != nil {
+		fmt.Println("FAIL: TestTypedTableNonUnique create table", err)
+		t.Fail()
+	}
+	users.Insert(typedUser{Id: "id1", Count: 1}, typedUser{Id: "id2", Count: 1}, typedUser{Id: "id3", Count: 2})
+	if len(users.GetAll("Count", 1)) != 2 || len(users.GetAll("Count", 2)) != 1 || len(users.GetAll("Count", 3)) != 0 {
+		fmt.Println("FAIL: TestTypedTableNonUnique get all")
+		t.Fail()
+	}
+
+	opts.Indexes["Email"] = sc.IndexOptions{}
+	if _, err = sc.NewTableWithOptions[typedUser](db, "users2", opts); err == nil {
+		fmt.Println("FAIL: TestTypedTableNonUnique missing index field")
+		t.Fail()
+	}
+}
@@ -0,0 +1,137 @@
+package sc
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Same as UpdateData but each row replaces the one stored under its value of the given unique index, rather than its
+// primary key. This is how to change a row's primary key eg. table.UpdateBy("Username", user) with a new user.Id
+func (tbl *Table) UpdateBy(index string, data... interface{}) error {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commit(tbl.updateBy(index, data...))
+}
+
+// Does the work of UpdateData and UpdateBy. Caller must hold the table write lock
+func (tbl *Table) updateBy(index string, data... interface{}) error {
+	idx, err := tbl.uniqueIndex(index)
+	if err != nil {
+		return err
+	}
+	// find every row first, then check none of them collide, so a bad row part way through changes nothing
+	recs := make([]*record, len(data))
+	targets := make(map[*record]bool, len(data))
+	for i, d := range data {
+		if err := tbl.checkRow(d); err != nil {
+			return err
+		}
+		key, ok := idx.keyOf(fieldsOf(d))
+		if !ok {
+			return errors.Wrapf(ErrNotFound, "UpdateData DNE: %v has no key for index %s", d, index)
+		}
+		rec, ok := tbl.liveRecord(index, key)
+		if !ok {
+			return errors.Wrapf(ErrNotFound, "UpdateData DNE: %v", d)
+		}
+		if targets[rec] {
+			return errors.Errorf("Row %v is updated twice in one batch", key)
+		}
+		recs[i] = rec
+		targets[rec] = true
+	}
+	if err := tbl.checkBatchKeys(data); err != nil {
+		return err
+	}
+	if err := tbl.checkUpdates(recs, targets, data); err != nil {
+		return err
+	}
+	for i, d := range data {
+		tbl.rewrite(recs[i], d)
+	}
+	// only once the whole batch is in, so eviction can't take a row the batch hasn't got to yet
+	tbl.enforceLimits()
+	return nil
+}
+
+// The named index, as long as it is unique and so can pick out the row to update. Caller must hold the table lock
+func (tbl *Table) uniqueIndex(index string) (Index, error) {
+	idx, ok := tbl.Indexes[index]
+	if !ok {
+		return idx, errors.Wrapf(ErrNotFound, "Index %s does not exist in table %s", index, tbl.Name)
+	}
+	if !idx.Unique {
+		return idx, errors.Errorf("Index %s is not unique so can't be used to find the row to update", index)
+	}
+	return idx, nil
+}
+
+// Make sure rows can replace the records they are updating without taking a unique key from some other row. A key
+// can be taken from another row in the same batch, as long as that row is moving off it, eg. to swap two usernames.
+// Caller must hold the table write lock
+func (tbl *Table) checkUpdates(recs []*record, targets map[*record]bool, data []interface{}) error {
+	// keys claimed by earlier rows in this batch, per index
+	batchKeys := make(map[string]map[interface{}]bool)
+	for name, idx := range tbl.Indexes {
+		if idx.Unique {
+			batchKeys[name] = make(map[interface{}]bool)
+		}
+	}
+	now := time.Now()
+	for i, d := range data {
+		fields := fieldsOf(d)
+		for name, idx := range tbl.Indexes {
+			if !idx.Unique {
+				continue
+			}
+			key, ok := idx.keyOf(fields)
+			if !ok {
+				continue
+			}
+			// expired rows are left for later rather than evicted here, in case one is in the batch
+			holder, held := idx.get(key)
+			held = held && !tbl.expired(holder, now)
+			if (held && holder != recs[i] && !targets[holder]) || batchKeys[name][key] {
+				tbl.db.logger.Debug("conflict", "table", tbl.Name, "index", name, "key", key)
+				return ErrDuplicateKey{Index: name, Key: key}
+			}
+			batchKeys[name][key] = true
+		}
+	}
+	return nil
+}
+
+// Replace the row in a record with d, moving it in only the indexes where its keys changed, then evict rows if the
+// table has grown past its limits. The row keeps its expiry time. If the primary key changed the evictor sees the old
+// one leave and the new one arrive. Caller must hold the table write lock
+func (tbl *Table) update(rec *record, d interface{}) {
+	tbl.rewrite(rec, d)
+	tbl.enforceLimits()
+}
+
+// Does the work of update apart from eviction. Caller must hold the table write lock
+func (tbl *Table) rewrite(rec *record, d interface{}) {
+	if tbl.copyRows {
+		d = copyRow(d)
+	}
+	tbl.logUndo(undoWrite, rec)
+	tbl.checkDrift(rec)
+	fields := fieldsOf(d)
+	old := fieldsOf(rec.row)
+	oldPK := rec.pk
+	rec.pk, _ = tbl.Indexes[tbl.pk].keyOf(fields)
+	rec.row = d
+	tbl.bump(rec)
+	moved := tbl.rekey(rec, old, fields)
+	if tbl.db.debug {
+		rec.keys = tbl.indexKeys(fields)
+	}
+	tbl.db.logger.Debug("update", "table", tbl.Name, "pk", rec.pk, "indexes", moved)
+	if rec.pk != oldPK && tbl.evictor != nil {
+		tbl.evictor.Removed(oldPK)
+		tbl.track(rec, false)
+	} else {
+		tbl.track(rec, true)
+	}
+}
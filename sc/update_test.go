@@ -0,0 +1,142 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"errors"
+	"time"
+)
+
+type updateTestObj struct {
+	Id string
+	Username string
+	Country string
+	Score int
+}
+
+func updateTestTable(opts sc.TableOptions) *sc.Table {
+	opts.PrimaryKey = "Id"
+	opts.Indexes = map[string]sc.IndexOptions{
+		"Username": {Unique: true},
+		"Country": {},
+		"Score": {Kind: sc.SortedIndex},
+	}
+	table, _ := sc.InitDb("testdb").AddTableWithOptions("users", opts)
+	table.InsertData(
+		updateTestObj{"id1", "alice", "NZ", 10},
+		updateTestObj{"id2", "bob", "NZ", 20},
+		updateTestObj{"id3", "carol", "US", 30},
+	)
+	return table
+}
+
+// Changing indexed fields moves the row in every index and leaves nothing under the old keys
+func TestUpdateRekeys(t *testing.T) {
+	table := updateTestTable(sc.TableOptions{})
+	if err := table.UpdateData(updateTestObj{"id1", "alicia", "US", 40}); err != nil {
+		fmt.Println("FAIL: TestUpdateRekeys", err)
+		t.Fail()
+	}
+	if table.LookupKey("alice", "Username") != nil || table.LookupKey("alicia", "Username").(updateTestObj).Id != "id1" {
+		fmt.Println("FAIL: TestUpdateRekeys unique key")
+		t.Fail()
+	}
+	if len(table.LookupAll("Country", "NZ")) != 1 || len(table.LookupAll("Country", "US")) != 2 {
+		fmt.Println("FAIL: TestUpdateRekeys non unique key")
+		t.Fail()
+	}
+	if rank, _ := table.Rank("Score", "id1"); rank != 2 {
+		fmt.Println("FAIL: TestUpdateRekeys sorted index", rank)
+		t.Fail()
+	}
+	if sc.GetTableSize(table) != 3 || len(table.Indexes["Username"].Idx) != 3 {
+		fmt.Println("FAIL: TestUpdateRekeys size", sc.GetTableSize(table))
+		t.Fail()
+	}
+}
+
+func TestUpdateConflicts(t *testing.T) {
+	table := updateTestTable(sc.TableOptions{})
+
+	// the second row takes carol's username so neither row is updated
+	err := table.UpdateData(updateTestObj{"id1", "alicia", "NZ", 10}, updateTestObj{"id2", "carol", "NZ", 20})
+	var dupErr sc.ErrDuplicateKey
+	if !errors.As(err, &dupErr) || dupErr.Index != "Username" || dupErr.Key != "carol" {
+		fmt.Println("FAIL: TestUpdateConflicts", err)
+		t.Fail()
+	}
+	if table.LookupKey("alice", "Username") == nil || table.LookupKey("alicia", "Username") != nil ||
+		table.LookupKey("bob", "Username") == nil {
+		fmt.Println("FAIL: TestUpdateConflicts batch partly applied")
+		t.Fail()
+	}
+
+	// two rows in a batch can't both move to the same key
+	err = table.UpdateData(updateTestObj{"id1", "dave", "NZ", 10}, updateTestObj{"id2", "dave", "NZ", 20})
+	if !errors.As(err, &dupErr) || dupErr.Key != "dave" {
+		fmt.Println("FAIL: TestUpdateConflicts same new key", err)
+		t.Fail()
+	}
+
+	// but can swap keys with each other
+	if err = table.UpdateData(updateTestObj{"id1", "bob", "NZ", 10}, updateTestObj{"id2", "alice", "NZ", 20}); err != nil {
+		fmt.Println("FAIL: TestUpdateConflicts swap", err)
+		t.Fail()
+	}
+	if table.LookupKey("alice", "Username").(updateTestObj).Id != "id2" || table.LookupKey("bob", "Username").(updateTestObj).Id != "id1" {
+		fmt.Println("FAIL: TestUpdateConflicts swapped keys")
+		t.Fail()
+	}
+
+	if err = table.UpdateData(updateTestObj{Id: "id9"}); !errors.Is(err, sc.ErrNotFound) {
+		fmt.Println("FAIL: TestUpdateConflicts missing row", err)
+		t.Fail()
+	}
+	if err = table.UpdateData(updateTestObj{Id: "id1"}, updateTestObj{Id: "id1"}); err == nil {
+		fmt.Println("FAIL: TestUpdateConflicts same row twice")
+		t.Fail()
+	}
+}
+
+// UpdateBy finds rows by another unique index, so even the primary key can change
+func TestUpdateBy(t *testing.T) {
+	table := updateTestTable(sc.TableOptions{MaxRows: 3, DefaultTTL: time.Hour})
+	if err := table.UpdateBy("Username", updateTestObj{"id10", "alice", "NZ", 10}); err != nil {
+		fmt.Println("FAIL: TestUpdateBy", err)
+		t.Fail()
+	}
+	if table.LookupKey("id1", "Id") != nil || table.LookupKey("id10", "Id").(updateTestObj).Username != "alice" {
+		fmt.Println("FAIL: TestUpdateBy primary key not moved")
+		t.Fail()
+	}
+	if rank, ok := table.Rank("Score", "id10"); !ok || rank != 0 {
+		fmt.Println("FAIL: TestUpdateBy rank by new primary key", rank, ok)
+		t.Fail()
+	}
+	if err := table.UpdateBy("Username", updateTestObj{"id2", "alice", "NZ", 10}); !errors.As(err, new(sc.ErrDuplicateKey)) {
+		fmt.Println("FAIL: TestUpdateBy onto another primary key", err)
+		t.Fail()
+	}
+
+	// the evictor follows the new primary key, bob was written longest ago so goes first
+	table.InsertData(updateTestObj{"id4", "dave", "US", 40})
+	if sc.GetTableSize(table) != 3 || table.LookupKey("bob", "Username") != nil || table.LookupKey("id10", "Id") == nil {
+		fmt.Println("FAIL: TestUpdateBy eviction", sc.GetTableSize(table))
+		t.Fail()
+	}
+	table.InsertData(updateTestObj{"id5", "erin", "US", 50})
+	if table.LookupKey("id10", "Id") == nil || table.LookupKey("carol", "Username") != nil {
+		fmt.Println("FAIL: TestUpdateBy updated row evicted before older ones")
+		t.Fail()
+	}
+
+	if err := table.UpdateBy("Country", updateTestObj{Country: "NZ"}); err == nil {
+		fmt.Println("FAIL: TestUpdateBy non unique index")
+		t.Fail()
+	}
+	if err := table.UpdateBy("Nope", updateTestObj{}); !errors.Is(err, sc.ErrNotFound) {
+		fmt.Println("FAIL: TestUpdateBy missing index", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,87 @@
+package sc
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Look up a row like LookupKey, along with its current version. ok is false if there is no row for that key.
+// Every write to a row gives it a new version, higher than any handed out before in the same table, so a row deleted
+// and inserted again doesn't get its old versions back. Pass the version to CompareAndSwap or UpdateIfVersion to only
+// write the row back if nobody else has written it in between
+func (tbl *Table) Get(index string, key interface{}) (row interface{}, version uint64, ok bool) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	rec := tbl.lookupRecord(key, index)
+	if rec == nil {
+		return nil, 0, false
+	}
+	return tbl.rowOut(rec), rec.version, true
+}
+
+// Replace the row stored under key in a unique index with newRow, but only if it is still at expectedVersion.
+// Fails with ErrVersionConflict if it has been written since, or ErrNotFound if it has gone. Otherwise newRow goes
+// in the same way as with UpdateBy, so it can change any key as long as it doesn't take one from another row.
+// Returns the new version, or on a conflict the version the row is at now
+func (tbl *Table) CompareAndSwap(index string, key interface{}, expectedVersion uint64, newRow interface{}) (uint64, error) {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commitVersion(tbl.compareAndSwap(index, key, expectedVersion, newRow))
+}
+
+// Update a single row found by its primary key like UpdateData, but only if it is still at expectedVersion.
+// Fails the same way as CompareAndSwap. Returns the new version
+func (tbl *Table) UpdateIfVersion(expectedVersion uint64, data interface{}) (uint64, error) {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	return tbl.commitVersion(tbl.updateIfVersion(expectedVersion, data))
+}
+
+// Does the work of UpdateIfVersion. Caller must hold the table write lock
+func (tbl *Table) updateIfVersion(expectedVersion uint64, data interface{}) (uint64, error) {
+	if err := tbl.checkRow(data); err != nil {
+		return 0, err
+	}
+	key, _ := tbl.Indexes[tbl.pk].keyOf(fieldsOf(data))
+	return tbl.compareAndSwap(tbl.pk, key, expectedVersion, data)
+}
+
+// commit for a compare and swap, which on a conflict still returns the version the row is at.
+// Caller must hold the table write lock
+func (tbl *Table) commitVersion(version uint64, err error) (uint64, error) {
+	if err != nil {
+		return version, err
+	}
+	if err = tbl.commit(nil); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Does the work of CompareAndSwap. Caller must hold the table write lock
+func (tbl *Table) compareAndSwap(index string, key interface{}, expectedVersion uint64, newRow interface{}) (uint64, error) {
+	if _, err := tbl.uniqueIndex(index); err != nil {
+		return 0, err
+	}
+	rec, ok := tbl.liveRecord(index, key)
+	if !ok {
+		return 0, errors.Wrapf(ErrNotFound, "Key %v in index %s", key, index)
+	}
+	if rec.version != expectedVersion {
+		tbl.db.logger.Debug("version conflict", "table", tbl.Name, "pk", rec.pk, "expected", expectedVersion, "version", rec.version)
+		return rec.version, errors.Wrapf(ErrVersionConflict, "Key %v in index %s is at version %d not %d", key, index, rec.version, expectedVersion)
+	}
+	if err := tbl.checkRow(newRow); err != nil {
+		return 0, err
+	}
+	if err := tbl.checkUpdates([]*record{rec}, map[*record]bool{rec: true}, []interface{}{newRow}); err != nil {
+		return 0, err
+	}
+	tbl.update(rec, newRow)
+	return rec.version, nil
+}
+
+// Give a record the next version, whenever its row is written. Caller must hold the table write lock
+func (tbl *Table) bump(rec *record) {
+	tbl.version++
+	rec.version = tbl.version
+}
@@ -0,0 +1,118 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"errors"
+	"sync"
+)
+
+type versionTestObj struct {
+	Id int
+	Username string
+	Count int
+}
+
+func TestVersions(t *testing.T) {
+	table, _ := sc.InitDb("testdb").AddTable("users", "Id", "Username")
+	table.InsertData(versionTestObj{1, "alice", 0}, versionTestObj{2, "bob", 0})
+	_, v1, ok := table.Get("Id", 1)
+	_, v2, _ := table.Get("Username", "bob")
+	if !ok || v1 == 0 || v2 <= v1 {
+		fmt.Println("FAIL: TestVersions insert", v1, v2, ok)
+		t.Fail()
+	}
+
+	// every kind of write moves the version on
+	last := v2
+	writes := []func(){
+		func() { table.SetData(versionTestObj{1, "alice", 1}) },
+		func() { table.UpdateData(versionTestObj{1, "alice", 2}) },
+		func() { table.Patch("Id", 1, map[string]interface{}{"Count": 3}) },
+		func() {
+			table.Delete("Id", 1)
+			table.InsertData(versionTestObj{1, "alice", 0})
+		},
+	}
+	for i, write := range writes {
+		write()
+		_, v, _ := table.Get("Id", 1)
+		if v <= last {
+			fmt.Println("FAIL: TestVersions write", i, v, last)
+			t.Fail()
+		}
+		last = v
+	}
+	if _, v, _ := table.Get("Id", 2); v != v2 {
+		fmt.Println("FAIL: TestVersions other row's version changed", v, v2)
+		t.Fail()
+	}
+	if row, v, ok := table.Get("Id", 9); ok || row != nil || v != 0 {
+		fmt.Println("FAIL: TestVersions missing row", row, v, ok)
+		t.Fail()
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	table, _ := sc.InitDb("testdb").AddTable("users", "Id", "Username")
+	table.InsertData(versionTestObj{1, "alice", 0}, versionTestObj{2, "bob", 0})
+	_, stale, _ := table.Get("Id", 1)
+	v, err := table.CompareAndSwap("Username", "alice", stale, versionTestObj{1, "alicia", 1})
+	if err != nil || v <= stale || table.LookupKey("alicia", "Username") == nil {
+		fmt.Println("FAIL: TestCompareAndSwap", v, err)
+		t.Fail()
+	}
+
+	// someone else wrote first
+	now, err := table.UpdateIfVersion(stale, versionTestObj{1, "alicia", 2})
+	if !errors.Is(err, sc.ErrVersionConflict) || now != v || table.LookupKey(1, "Id").(versionTestObj).Count != 1 {
+		fmt.Println("FAIL: TestCompareAndSwap stale version", now, err)
+		t.Fail()
+	}
+	if _, err = table.UpdateIfVersion(v, versionTestObj{1, "bob", 2}); !errors.As(err, new(sc.ErrDuplicateKey)) {
+		fmt.Println("FAIL: TestCompareAndSwap duplicate key", err)
+		t.Fail()
+	}
+	if _, err = table.UpdateIfVersion(v, versionTestObj{3, "carol", 0}); !errors.Is(err, sc.ErrNotFound) {
+		fmt.Println("FAIL: TestCompareAndSwap missing row", err)
+		t.Fail()
+	}
+	if _, v, _ := table.Get("Id", 1); v != now {
+		fmt.Println("FAIL: TestCompareAndSwap failed swaps changed the version", v, now)
+		t.Fail()
+	}
+}
+
+// Workers retrying on conflict never lose each other's increments
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	users, _ := sc.NewTable[versionTestObj](sc.InitDb("testdb"), "users", "Id")
+	users.Insert(versionTestObj{Id: 1})
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				for {
+					row, version, _ := users.GetWithVersion("Id", 1)
+					row.Count++
+					_, err := users.UpdateIfVersion(version, row)
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, sc.ErrVersionConflict) {
+						fmt.Println("FAIL: TestCompareAndSwapConcurrent", err)
+						t.Fail()
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if row, _ := users.Get("Id", 1); row.Count != 400 {
+		fmt.Println("FAIL: TestCompareAndSwapConcurrent lost updates", row.Count)
+		t.Fail()
+	}
+}
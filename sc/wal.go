@@ -0,0 +1,505 @@
+package sc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Name of the write-ahead log in the directory given to Open
+const walFile = "sc.wal"
+
+// Each record is its payload's length and CRC-32C, a CRC-32C of those two so a damaged length can be told from a
+// torn one, then the gob encoded payload
+const walHeaderSize = 12
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func init() {
+	// map rows and what's in them travel as interface{} values, which gob can only decode once told their types
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register(time.Time{})
+}
+
+// When the write-ahead log is flushed to disk, see WithSync
+type SyncPolicy struct {
+	// fsync before every write returns, so a write which succeeded survives the machine crashing. The default
+	Always bool
+	// Otherwise fsync this often in the background, so a crash loses at most this much. 0 leaves it to the OS, which
+	// survives the process crashing but not the machine
+	Every time.Duration
+}
+
+var (
+	SyncAlways = SyncPolicy{Always: true}
+	SyncNever = SyncPolicy{}
+)
+
+// Background fsync every d, see SyncPolicy
+func SyncEvery(d time.Duration) SyncPolicy {
+	return SyncPolicy{Every: d}
+}
+
+// When Open flushes the write-ahead log to disk. Defaults to SyncAlways, has no effect on InitDb
+func WithSync(policy SyncPolicy) Option {
+	return func(db *Database) {
+		db.syncPolicy = policy
+	}
+}
+
+// Append only log of every change to a db opened with Open, which is replayed to rebuild it next time
+type wal struct {
+	mu sync.Mutex
+	f *os.File
+	// end of the last record written whole, where a failed write gets cut back to
+	size int64
+	policy SyncPolicy
+	// written to since the last fsync
+	dirty bool
+	// once set every append fails with it, eg. after an fsync failed and what made it to disk is unknown
+	err error
+	// stop and wait for the background syncer, nil unless policy.Every is set
+	stop chan struct{}
+	done chan struct{}
+	logger *slog.Logger
+}
+
+// What kind of change a walOp is
+type walOpKind int
+
+const (
+	walPut walOpKind = iota
+	walDelete
+	walClean
+	walCreateTable
+	walDropTable
+	walAddIndex
+	walDropIndex
+)
+
+// A single change to a db. A record holds every change made by one write or transaction so they replay together
+type walOp struct {
+	Kind walOpKind
+	Table string
+	// the row put, or for a delete the row as it was, which its primary key is worked out from on replay
+	Row interface{}
+	// when a put row expires, zero if it doesn't
+	Expires time.Time
+	// the table created
+	TableOptions walTableOptions
+	// the index added or dropped
+	Index string
+	IndexOptions IndexOptions
+}
+
+// The part of TableOptions which can be written to the log. Eviction and OnEvict are functions so they aren't kept
+type walTableOptions struct {
+	PrimaryKey string
+	Indexes map[string]IndexOptions
+	DefaultTTL time.Duration
+	MaxRows int
+	MaxBytes int64
+	CopyRows bool
+}
+
+// Open a db which keeps a write-ahead log in dir, creating dir if it isn't there. The tables, indexes and rows written
+// by the last process to open dir are rebuilt from the log first. A record left half written by a crash is cut off
+// the end of the log. A bad record with more of the log after it can't be from a crash, so rather than lose the
+// records after it Open fails with ErrCorrupt and leaves the log alone.
+// From then on every write is appended to the log before it returns, and a write which can't be logged is undone and
+// fails. See SyncPolicy for when the log reaches the disk, Close the db to flush and close it.
+// Rows are written with encoding/gob, so only their exported fields are kept and the type of every row must be
+// registered with gob.Register before Open, eg. gob.Register(User{}). Rows come back as the registered type, so a
+// table of *User rows needs gob.Register(&User{}). Maps, slices and time.Time in map rows are already registered.
+// Not kept: row versions, which start again, and TableOptions.Eviction and OnEvict, so a table with MaxRows or
+// MaxBytes goes back to LRU. Use GetTable to get at the tables again
+func Open(dir string, opts... Option) (*Database, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Creating db directory %s", dir)
+	}
+	db := InitDb(filepath.Base(dir), append([]Option{WithSync(SyncAlways)}, opts...)...)
+	path := filepath.Join(dir, walFile)
+	good, err := db.replay(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Opening WAL %s", path)
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() > good {
+		db.logger.Warn("wal truncated", "path", path, "offset", good, "dropped", info.Size() - good)
+		if err = f.Truncate(good); err == nil {
+			err = f.Sync()
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "Truncating torn tail of WAL %s", path)
+	}
+	w := &wal{f: f, size: good, policy: db.syncPolicy, logger: db.logger}
+	if !w.policy.Always && w.policy.Every > 0 {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncer()
+	}
+	db.wal = w
+	for _, tbl := range db.Tables {
+		tbl.wal = w
+	}
+	return db, nil
+}
+
+// Apply every whole record in the log at path to the db, which isn't logging yet. Returns the offset just past the
+// last good record, anything after which is a torn tail. A good record which can't be applied is an error since
+// cutting it off would lose data, eg. its row type wasn't registered with gob, and so is a bad record which isn't
+// the last one
+func (db *Database) replay(path string) (int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "Opening WAL %s", path)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, errors.Wrapf(err, "Opening WAL %s", path)
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	records := 0
+	for {
+		payload, err := readRecord(r, info.Size() - offset)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrCorrupt) {
+			return 0, errors.Wrapf(err, "WAL %s record at offset %d", path, offset)
+		}
+		if err != nil {
+			db.logger.Warn("wal torn record", "path", path, "offset", offset, "err", err)
+			break
+		}
+		var ops []walOp
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&ops); err != nil {
+			return 0, errors.Wrapf(err, "Decoding WAL %s record at offset %d", path, offset)
+		}
+		if err := db.apply(ops); err != nil {
+			return 0, errors.Wrapf(err, "Replaying WAL %s record at offset %d", path, offset)
+		}
+		offset += int64(walHeaderSize + len(payload))
+		records++
+	}
+	db.logger.Debug("replay", "path", path, "records", records, "tables", len(db.Tables))
+	return offset, nil
+}
+
+// Read the next record's payload, checking it against its checksum. left is how much of the file there is to read,
+// so a garbage length can't make it allocate more. io.EOF means the log ended cleanly between records, and
+// ErrCorrupt that the record is bad but isn't the last one. Anything else is a torn tail
+func readRecord(r io.Reader, left int64) ([]byte, error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrap(err, "Short record header")
+	}
+	if crc32.Checksum(header[0:8], crcTable) != binary.LittleEndian.Uint32(header[8:12]) {
+		// the length can't be trusted so there's no telling where the record ends. A crash can leave the end of the
+		// file zeroed, which is the only way a whole header goes bad at the end of it
+		if isZero(header[:]) && onlyZeros(r) {
+			return nil, errors.New("Zeroed record header")
+		}
+		return nil, errors.Wrap(ErrCorrupt, "Record header checksum mismatch")
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if int64(size) > left - walHeaderSize {
+		return nil, errors.Errorf("Record length %d runs past the end of the log", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.Wrap(err, "Short record")
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		// a crash only leaves the last record half written
+		if after := left - walHeaderSize - int64(size); after > 0 {
+			return nil, errors.Wrapf(ErrCorrupt, "Record checksum mismatch with %d bytes after it", after)
+		}
+		return nil, errors.New("Record checksum mismatch")
+	}
+	return payload, nil
+}
+
+// Whether every byte is 0
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the rest of r is all 0 bytes
+func onlyZeros(r io.Reader) bool {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if !isZero(buf[:n]) {
+			return false
+		}
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// Make the changes in one record
+func (db *Database) apply(ops []walOp) error {
+	for _, op := range ops {
+		if op.Kind == walCreateTable {
+			o := op.TableOptions
+			opts := TableOptions{
+				PrimaryKey: o.PrimaryKey,
+				Indexes: o.Indexes,
+				DefaultTTL: o.DefaultTTL,
+				MaxRows: o.MaxRows,
+				MaxBytes: o.MaxBytes,
+				CopyRows: o.CopyRows,
+			}
+			// addTable is what logged it, and takes the tables AddTable makes without a primary key
+			if _, err := db.addTable(op.Table, opts); err != nil {
+				return err
+			}
+			continue
+		}
+		if op.Kind == walDropTable {
+			if err := db.DropTable(op.Table); err != nil {
+				return err
+			}
+			continue
+		}
+		tbl, ok := db.GetTable(op.Table)
+		if !ok {
+			return errors.Wrapf(ErrNotFound, "Table %s", op.Table)
+		}
+		switch op.Kind {
+		case walPut:
+			if err := tbl.replayPut(op.Row, op.Expires); err != nil {
+				return err
+			}
+		case walDelete:
+			if err := tbl.replayDelete(op.Row); err != nil {
+				return err
+			}
+		case walClean:
+			tbl.CleanTableData()
+		case walAddIndex:
+			if err := tbl.AddIndex(op.Index, op.IndexOptions); err != nil {
+				return err
+			}
+		case walDropIndex:
+			if err := tbl.DropIndex(op.Index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Set a row from the log with whatever is left of its TTL. A row which has expired since is removed instead
+func (tbl *Table) replayPut(row interface{}, expires time.Time) error {
+	var ttl time.Duration
+	if !expires.IsZero() {
+		if ttl = time.Until(expires); ttl <= 0 {
+			return tbl.replayDelete(row)
+		}
+	}
+	return tbl.SetDataWithTTL(ttl, row)
+}
+
+// Remove the row with the same primary key as row, if it's still there
+func (tbl *Table) replayDelete(row interface{}) error {
+	tbl.mu.Lock()
+	defer tbl.unlock()
+	if err := tbl.checkRow(row); err != nil {
+		return err
+	}
+	key, _ := tbl.Indexes[tbl.pk].keyOf(fieldsOf(row))
+	tbl.deleteKey(tbl.pk, key)
+	return nil
+}
+
+// Write ops as one record, syncing it to disk first if the policy says so. Nothing is written if ops can't be encoded
+func (w *wal) append(ops []walOp) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, walHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(ops); err != nil {
+		return errors.Wrap(err, "Encoding WAL record, rows must be registered with gob.Register")
+	}
+	rec := buf.Bytes()
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(len(rec) - walHeaderSize))
+	binary.LittleEndian.PutUint32(rec[4:8], crc32.Checksum(rec[walHeaderSize:], crcTable))
+	binary.LittleEndian.PutUint32(rec[8:12], crc32.Checksum(rec[0:8], crcTable))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := w.f.Write(rec); err != nil {
+		// cut off whatever part of the record made it so the next one starts in the right place
+		if terr := w.f.Truncate(w.size); terr != nil {
+			w.err = errors.Wrap(terr, "WAL unusable after a failed write")
+		}
+		return errors.Wrap(err, "Writing WAL")
+	}
+	w.size += int64(len(rec))
+	if !w.policy.Always {
+		w.dirty = true
+		return nil
+	}
+	if err := w.f.Sync(); err != nil {
+		// the record may or may not be on disk, so stop taking writes rather than guess
+		w.err = errors.Wrap(err, "Syncing WAL")
+		return w.err
+	}
+	return nil
+}
+
+// fsync anything written since last time
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty || w.err != nil {
+		return w.err
+	}
+	if err := w.f.Sync(); err != nil {
+		w.err = errors.Wrap(err, "Syncing WAL")
+		return w.err
+	}
+	w.dirty = false
+	return nil
+}
+
+// Every policy.Every sync the log until stopped
+func (w *wal) syncer() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.policy.Every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.sync(); err != nil {
+				w.logger.Error("wal sync", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// Stop the syncer, then flush and close the file. Appends afterwards fail with ErrClosed
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	err := w.sync()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cerr := w.f.Close(); err == nil && cerr != nil {
+		err = errors.Wrap(cerr, "Closing WAL")
+	}
+	w.err = errors.Wrap(ErrClosed, "WAL")
+	return err
+}
+
+// Append ops which change the layout of the db, eg. adding a table, before making the change.
+// A no-op unless the db was opened with Open
+func (db *Database) logSchema(op walOp) error {
+	if db.wal == nil {
+		return nil
+	}
+	return db.wal.append([]walOp{op})
+}
+
+// Append what the write holding the table lock changed to the log, or undo all of it if that fails and return why.
+// Changes are only collected while there's a log or a transaction, which logs its changes itself when it commits.
+// err is from the write: a write which failed is returned as is and whatever it changed on the way, eg. expired rows
+// it removed, is logged when the lock is released. Caller must hold the table write lock
+func (tbl *Table) commit(err error) error {
+	if err != nil || tbl.wal == nil || tbl.inTx || len(tbl.undo) == 0 {
+		return err
+	}
+	if err := tbl.wal.append(tbl.changes()); err != nil {
+		tbl.db.logger.Error("wal", "table", tbl.Name, "err", err)
+		tbl.rollback()
+		return err
+	}
+	tbl.undo = nil
+	return nil
+}
+
+// What the undo log says changed, as log ops in the order the writes were made, so that replaying them leaves every
+// unique key with the row which holds it now. Each write is put as it left the row, which is what the next entry for
+// the same record has, or the record as it is now after its last one. A write followed straight away by another to
+// the same record, eg. setting its expiry, is left to that one. Caller must hold the table write lock
+func (tbl *Table) changes() []walOp {
+	after := make([]record, len(tbl.undo))
+	// whether the next write to be made after each entry is to the same record
+	continued := make([]bool, len(tbl.undo))
+	later := make(map[*record]record, len(tbl.undo))
+	var next *record
+	for i := len(tbl.undo) - 1; i >= 0; i-- {
+		u := tbl.undo[i]
+		// losing a key to another row isn't a change to this one, replaying the other row's write does the same
+		if u.op == undoKey {
+			continue
+		}
+		if was, ok := later[u.rec]; ok {
+			after[i] = was
+		} else {
+			after[i] = *u.rec
+		}
+		later[u.rec] = u.was
+		continued[i] = next == u.rec
+		next = u.rec
+	}
+	var ops []walOp
+	for i, u := range tbl.undo {
+		switch {
+		case u.op == undoKey:
+		case u.op == undoDelete:
+			ops = append(ops, walOp{Kind: walDelete, Table: tbl.Name, Row: u.was.row})
+		default:
+			// a row which moved to another primary key leaves the old one behind
+			if u.op == undoWrite && after[i].pk != u.was.pk {
+				ops = append(ops, walOp{Kind: walDelete, Table: tbl.Name, Row: u.was.row})
+			}
+			if !continued[i] {
+				ops = append(ops, walOp{Kind: walPut, Table: tbl.Name, Row: after[i].row, Expires: after[i].expires})
+			}
+		}
+	}
+	return ops
+}
@@ -0,0 +1,407 @@
+package sc_test
+
+import (
+	"godb/sc"
+	"testing"
+	"fmt"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type walUser struct {
+	Id int
+	Username string
+	Score int
+	Tags []string
+}
+
+type walAudit struct {
+	Id int
+	UserId int
+}
+
+// never registered with gob
+type walUnregistered struct {
+	Id int
+	UserId int
+}
+
+func init() {
+	gob.Register(walUser{})
+	gob.Register(walAudit{})
+}
+
+func walTestDb(t *testing.T, dir string, opts... sc.Option) *sc.Database {
+	db, err := sc.Open(dir, opts...)
+	if err != nil {
+		fmt.Println("FAIL: Open", err)
+		t.FailNow()
+	}
+	return db
+}
+
+func walUsers(db *sc.Database) *sc.Table {
+	users, _ := db.AddTableWithOptions("users", sc.TableOptions{
+		PrimaryKey: "Id",
+		Indexes: map[string]sc.IndexOptions{
+			"Username": {Unique: true},
+			"Score": {Kind: sc.SortedIndex},
+		},
+	})
+	return users
+}
+
+// Everything written comes back after reopening
+func TestOpenReplay(t *testing.T) {
+	dir := t.TempDir()
+	db := walTestDb(t, dir)
+	users := walUsers(db)
+	users.InsertData(walUser{1, "alice", 10, nil}, walUser{2, "bob", 20, nil}, walUser{3, "carol", 30, []string{"go"}})
+	users.UpdateData(walUser{1, "alicia", 15, nil})
+	users.UpdateBy("Username", walUser{20, "bob", 25, nil})
+	users.Delete("Id", 3)
+	users.Patch("Id", 1, map[string]interface{}{"Score": 17})
+	users.SetDataWithTTL(time.Hour, walUser{4, "dave", 40, []string{"a", "b"}})
+	users.SetDataWithTTL(time.Millisecond, walUser{5, "erin", 50, nil})
+	users.AddIndex("Tags", sc.IndexOptions{Multi: true})
+	users.InsertData(walUser{6, "frank", 60, []string{"b"}})
+	docs, _ := db.AddTable("docs", "Id")
+	docs.InsertData(map[string]interface{}{"Id": "x", "Created": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), "Tags": []interface{}{"a", 1}})
+	gone, _ := db.AddTable("gone", "Id")
+	gone.InsertData(walAudit{1, 1})
+	db.DropTable("gone")
+	cleaned, _ := db.AddTable("cleaned", "Id")
+	cleaned.InsertData(walAudit{1, 1})
+	cleaned.CleanTableData()
+	db.AddTable("audit", "Id")
+	db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		a, _ := tx.Table("audit")
+		u.UpdateData(walUser{2, "x", 0, nil})
+		u.Delete("Id", 20)
+		return a.InsertData(walAudit{1, 20})
+	})
+	db.Update(func(tx *sc.Tx) error {
+		a, _ := tx.Table("audit")
+		a.InsertData(walAudit{2, 2})
+		return errors.New("rolled back")
+	})
+	if err := db.Close(); err != nil {
+		fmt.Println("FAIL: TestOpenReplay close", err)
+		t.Fail()
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	db = walTestDb(t, dir)
+	defer db.Close()
+	if _, ok := db.GetTable("gone"); ok || len(db.ListTableNames()) != 4 {
+		fmt.Println("FAIL: TestOpenReplay tables", db.ListTableNames())
+		t.Fail()
+	}
+	users, _ = db.GetTable("users")
+	if sc.GetTableSize(users) != 3 || users.LookupKey("alicia", "Username").(walUser).Score != 17 {
+		fmt.Println("FAIL: TestOpenReplay users", sc.GetTableSize(users))
+		t.Fail()
+	}
+	if users.LookupKey(20, "Id") != nil || users.LookupKey(2, "Id") != nil || users.LookupKey(3, "Id") != nil || users.LookupKey(5, "Id") != nil {
+		fmt.Println("FAIL: TestOpenReplay deleted rows back")
+		t.Fail()
+	}
+	if len(users.LookupAll("Tags", "b")) != 2 {
+		fmt.Println("FAIL: TestOpenReplay added index", users.LookupAll("Tags", "b"))
+		t.Fail()
+	}
+	if top, _ := users.RangeByRank("Score", -1, -1); len(top) != 1 || top[0].(walUser).Id != 6 {
+		fmt.Println("FAIL: TestOpenReplay sorted index", top)
+		t.Fail()
+	}
+	docs, _ = db.GetTable("docs")
+	if doc, _ := docs.LookupKey("x", "Id").(map[string]interface{}); doc == nil || doc["Created"].(time.Time).Year() != 2020 || fmt.Sprint(doc["Tags"]) != "[a 1]" {
+		fmt.Println("FAIL: TestOpenReplay map row", doc)
+		t.Fail()
+	}
+	cleaned, _ = db.GetTable("cleaned")
+	audit, _ := db.GetTable("audit")
+	if sc.GetTableSize(cleaned) != 0 || sc.GetTableSize(audit) != 1 || audit.LookupKey(1, "Id") == nil {
+		fmt.Println("FAIL: TestOpenReplay clean or transaction", sc.GetTableSize(cleaned), sc.GetTableSize(audit))
+		t.Fail()
+	}
+
+	// and the reopened db keeps logging
+	users.Delete("Id", 1)
+	db.Close()
+	db = walTestDb(t, dir)
+	defer db.Close()
+	if users, _ = db.GetTable("users"); sc.GetTableSize(users) != 2 {
+		fmt.Println("FAIL: TestOpenReplay second reopen", sc.GetTableSize(users))
+		t.Fail()
+	}
+	// rows keep what was left of their TTL
+	if users.RemoveExpired(time.Now().Add(59 * time.Minute)) != 0 || users.RemoveExpired(time.Now().Add(time.Hour)) != 1 {
+		fmt.Println("FAIL: TestOpenReplay ttl")
+		t.Fail()
+	}
+}
+
+// A table made without any indexes can be opened again
+func TestOpenTableWithoutIndexes(t *testing.T) {
+	dir := t.TempDir()
+	db := walTestDb(t, dir)
+	table, err := db.AddTable("plain")
+	if err != nil {
+		fmt.Println("FAIL: TestOpenTableWithoutIndexes add", err)
+		t.FailNow()
+	}
+	table.InsertData(walAudit{1, 1})
+	table.AddIndex("Id", sc.IndexOptions{Unique: true})
+	table.InsertData(walAudit{2, 2})
+	db.Close()
+
+	db, err = sc.Open(dir)
+	if err != nil {
+		fmt.Println("FAIL: TestOpenTableWithoutIndexes reopen", err)
+		t.FailNow()
+	}
+	defer db.Close()
+	table, ok := db.GetTable("plain")
+	if !ok || sc.GetTableSize(table) != 2 || table.LookupKey(2, "Id") == nil {
+		fmt.Println("FAIL: TestOpenTableWithoutIndexes rows", ok)
+		t.Fail()
+	}
+}
+
+// A unique key which moves between rows in one write ends up with the same row after reopening
+func TestOpenReplayKeyMoves(t *testing.T) {
+	dir := t.TempDir()
+	db := walTestDb(t, dir)
+	users := walUsers(db)
+	users.SetData(walUser{1, "bob", 10, nil}, walUser{2, "bob", 20, nil}, walUser{1, "bob", 11, nil})
+	db.Update(func(tx *sc.Tx) error {
+		u, _ := tx.Table("users")
+		u.SetData(walUser{3, "carol", 30, nil})
+		u.SetData(walUser{4, "carol", 40, nil})
+		return u.SetData(walUser{3, "carol", 31, nil})
+	})
+	db.Close()
+
+	db = walTestDb(t, dir)
+	defer db.Close()
+	users, _ = db.GetTable("users")
+	if bob, _ := users.LookupKey("bob", "Username").(walUser); bob.Id != 1 || bob.Score != 11 {
+		fmt.Println("FAIL: TestOpenReplayKeyMoves batch", bob)
+		t.Fail()
+	}
+	if carol, _ := users.LookupKey("carol", "Username").(walUser); carol.Id != 3 || carol.Score != 31 {
+		fmt.Println("FAIL: TestOpenReplayKeyMoves transaction", carol)
+		t.Fail()
+	}
+}
+
+// A record cut short by a crash is dropped from the end of the log along with anything after it
+func TestOpenTornTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sc.wal")
+	db := walTestDb(t, dir)
+	users := walUsers(db)
+	users.InsertData(walUser{1, "alice", 10, nil})
+	db.Close()
+	info, _ := os.Stat(path)
+	good := info.Size()
+
+	db = walTestDb(t, dir)
+	users, _ = db.GetTable("users")
+	users.InsertData(walUser{2, "bob", 20, nil})
+	db.Close()
+	os.Truncate(path, good + 5)
+
+	db = walTestDb(t, dir)
+	users, _ = db.GetTable("users")
+	if sc.GetTableSize(users) != 1 || users.LookupKey(1, "Id") == nil {
+		fmt.Println("FAIL: TestOpenTornTail rows", sc.GetTableSize(users))
+		t.Fail()
+	}
+	if info, _ := os.Stat(path); info.Size() != good {
+		fmt.Println("FAIL: TestOpenTornTail not truncated", info.Size(), good)
+		t.Fail()
+	}
+	// new records go after the last good one
+	users.InsertData(walUser{3, "carol", 30, nil})
+	db.Close()
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0xff, 0xff, 0xff, 0x7f, 1, 2})
+	f.Close()
+	db = walTestDb(t, dir)
+	defer db.Close()
+	users, _ = db.GetTable("users")
+	if sc.GetTableSize(users) != 2 || users.LookupKey(3, "Id") == nil {
+		fmt.Println("FAIL: TestOpenTornTail garbage tail", sc.GetTableSize(users))
+		t.Fail()
+	}
+}
+
+// A last record which doesn't match its checksum is treated as torn
+func TestOpenBadChecksum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sc.wal")
+	db := walTestDb(t, dir)
+	users := walUsers(db)
+	users.InsertData(walUser{1, "alice", 10, nil})
+	users.InsertData(walUser{2, "bob", 20, nil})
+	db.Close()
+	data, _ := os.ReadFile(path)
+	data[len(data) - 3] ^= 0xff
+	os.WriteFile(path, data, 0644)
+
+	db = walTestDb(t, dir)
+	defer db.Close()
+	users, _ = db.GetTable("users")
+	if sc.GetTableSize(users) != 1 || users.LookupKey(2, "Id") != nil {
+		fmt.Println("FAIL: TestOpenBadChecksum", sc.GetTableSize(users))
+		t.Fail()
+	}
+}
+
+// A bad record in the middle of the log isn't cut off along with the good ones after it, Open fails instead
+func TestOpenCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sc.wal")
+	db := walTestDb(t, dir)
+	users := walUsers(db)
+	users.InsertData(walUser{1, "alice", 10, nil})
+	info, _ := os.Stat(path)
+	first := info.Size()
+	users.InsertData(walUser{2, "bob", 20, nil})
+	users.InsertData(walUser{3, "carol", 30, nil})
+	db.Close()
+	data, _ := os.ReadFile(path)
+	data[first + 20] ^= 0xff
+	os.WriteFile(path, data, 0644)
+
+	if _, err := sc.Open(dir); !errors.Is(err, sc.ErrCorrupt) {
+		fmt.Println("FAIL: TestOpenCorrupt opened", err)
+		t.Fail()
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		fmt.Println("FAIL: TestOpenCorrupt log changed", len(after), len(data))
+		t.Fail()
+	}
+
+	// a damaged length would otherwise look like a record running off the end
+	data[first + 20] ^= 0xff
+	data[first + 2] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	if _, err := sc.Open(dir); !errors.Is(err, sc.ErrCorrupt) {
+		fmt.Println("FAIL: TestOpenCorrupt opened with a bad length", err)
+		t.Fail()
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		fmt.Println("FAIL: TestOpenCorrupt log changed by a bad length", len(after), len(data))
+		t.Fail()
+	}
+}
+
+// A crash can leave zeros where the last records should be, which are cut off like any torn tail
+func TestOpenZeroedTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sc.wal")
+	db := walTestDb(t, dir)
+	users := walUsers(db)
+	users.InsertData(walUser{1, "alice", 10, nil})
+	db.Close()
+	info, _ := os.Stat(path)
+	good := info.Size()
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(make([]byte, 5000))
+	f.Close()
+
+	db = walTestDb(t, dir)
+	defer db.Close()
+	users, _ = db.GetTable("users")
+	if sc.GetTableSize(users) != 1 {
+		fmt.Println("FAIL: TestOpenZeroedTail rows", sc.GetTableSize(users))
+		t.Fail()
+	}
+	if info, _ := os.Stat(path); info.Size() != good {
+		fmt.Println("FAIL: TestOpenZeroedTail not truncated", info.Size(), good)
+		t.Fail()
+	}
+}
+
+// A write which can't be logged doesn't happen
+func TestWALUnloggedWrite(t *testing.T) {
+	dir := t.TempDir()
+	db := walTestDb(t, dir)
+	table, _ := db.AddTable("rows", "Id", "UserId")
+	table.InsertData(walAudit{1, 1})
+	if err := table.InsertData(walUnregistered{2, 0}); err == nil || table.LookupKey(2, "Id") != nil {
+		fmt.Println("FAIL: TestWALUnloggedWrite unregistered type", err)
+		t.Fail()
+	}
+	if _, _, err := table.Modify("Id", 1, func(row interface{}) (interface{}, error) { return walUnregistered{1, 0}, nil }); err == nil {
+		fmt.Println("FAIL: TestWALUnloggedWrite modify", err)
+		t.Fail()
+	}
+	if row, ok := table.LookupKey(1, "Id").(walAudit); !ok || row.UserId != 1 {
+		fmt.Println("FAIL: TestWALUnloggedWrite row changed", row)
+		t.Fail()
+	}
+	// a unique key the failed write took goes back to its row
+	if err := table.SetData(walUnregistered{5, 1}); err == nil || table.LookupKey(1, "UserId") == nil {
+		fmt.Println("FAIL: TestWALUnloggedWrite key not given back", err)
+		t.Fail()
+	}
+	err := db.Update(func(tx *sc.Tx) error {
+		rows, _ := tx.Table("rows")
+		rows.Delete("Id", 1)
+		return rows.InsertData(walUnregistered{3, 0})
+	})
+	if err == nil || table.LookupKey(1, "Id") == nil || table.LookupKey(3, "Id") != nil {
+		fmt.Println("FAIL: TestWALUnloggedWrite transaction", err)
+		t.Fail()
+	}
+
+	db.Close()
+	if err := table.InsertData(walAudit{4, 4}); !errors.Is(err, sc.ErrClosed) || table.LookupKey(4, "Id") != nil {
+		fmt.Println("FAIL: TestWALUnloggedWrite after close", err)
+		t.Fail()
+	}
+	if err := db.DropTable("rows"); !errors.Is(err, sc.ErrClosed) {
+		fmt.Println("FAIL: TestWALUnloggedWrite drop after close", err)
+		t.Fail()
+	}
+	if _, ok := db.GetTable("rows"); !ok {
+		fmt.Println("FAIL: TestWALUnloggedWrite unlogged drop happened")
+		t.Fail()
+	}
+	db = walTestDb(t, dir)
+	defer db.Close()
+	table, _ = db.GetTable("rows")
+	if sc.GetTableSize(table) != 1 {
+		fmt.Println("FAIL: TestWALUnloggedWrite replayed", sc.GetTableSize(table))
+		t.Fail()
+	}
+}
+
+func TestWALSyncPolicies(t *testing.T) {
+	for _, policy := range []sc.SyncPolicy{sc.SyncEvery(time.Millisecond), sc.SyncNever} {
+		dir := t.TempDir()
+		db := walTestDb(t, dir, sc.WithSync(policy))
+		users := walUsers(db)
+		for i := 0; i < 20; i++ {
+			users.InsertData(walUser{Id: i, Username: fmt.Sprint("user", i)})
+			time.Sleep(100 * time.Microsecond)
+		}
+		db.Close()
+		db = walTestDb(t, dir)
+		users, _ = db.GetTable("users")
+		if sc.GetTableSize(users) != 20 {
+			fmt.Println("FAIL: TestWALSyncPolicies", policy, sc.GetTableSize(users))
+			t.Fail()
+		}
+		db.Close()
+	}
+}